@@ -0,0 +1,58 @@
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+// Check the structure of Epub files.
+//
+// Usage:
+//
+//	epubvalidate FILE.epub...
+//
+// Prints every problem found.  Exits with a non-zero status if any file has
+// errors.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/HalCanary/facility/ebook"
+)
+
+func validate(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	issues, err := ebook.Validate(f, stat.Size())
+	if err != nil {
+		return false, err
+	}
+	for _, issue := range issues {
+		fmt.Printf("%s: %s\n", path, issue)
+	}
+	return !issues.HasErrors(), nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s FILE.epub...\n", os.Args[0])
+		os.Exit(2)
+	}
+	status := 0
+	for _, path := range os.Args[1:] {
+		ok, err := validate(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+		if !ok {
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
@@ -5,6 +5,8 @@ package dom
 
 import (
 	"bytes"
+	"encoding/xml"
+	"strings"
 	"testing"
 
 	"github.com/HalCanary/facility/expect"
@@ -57,3 +59,38 @@ func TestDom(t *testing.T) {
 	result := FindNodesByTagAndAttrib(x, "meta", "", "")
 	expect.Equal(t, 2, len(result))
 }
+
+const xhtmlSource = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en"><head><title>T</title></head><body><p id="a"/><p epub:type="x">caf&eacute; &amp; <a href="#a">link</a></p><!-- c --></body></html>
+`
+
+func TestParseXHTML(t *testing.T) {
+	doc, err := ParseXHTML(bytes.NewReader([]byte(xhtmlSource)))
+	expect.True(t, err == nil)
+	body := FindNodeByTag(doc, "body")
+	expect.True(t, body != nil && body.FirstChild != nil && body.FirstChild.FirstChild == nil)
+	var b bytes.Buffer
+	RenderXHTMLDoc(FindNodeByTag(doc, "html"), &b)
+	expect.Equal(t, strings.Replace(xhtmlSource, "&eacute;", "\u00e9", 1), b.String())
+}
+
+func TestClone(t *testing.T) {
+	x := maketest()
+	y := Clone(FindNodeByTag(x, "body"))
+	expect.True(t, y.Parent == nil)
+	p := FindNodeById(y, "foo")
+	p.Attr[0].Val = "bar"
+	expect.True(t, FindNodeById(x, "foo") != nil)
+	expect.Equal(t, "\n\nhi", ExtractText(y))
+}
+
+func TestDoctype(t *testing.T) {
+	doc := &Node{Type: DocumentNode}
+	Append(doc, XHTML11Doctype(), Text("\n"), Elem("html"))
+	var b bytes.Buffer
+	RenderXHTMLDoc(doc, &b)
+	expect.Equal(t, xml.Header+`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">`+
+		"\n<html/>\n", b.String())
+	parsed, err := ParseXHTML(bytes.NewReader(b.Bytes()))
+	expect.True(t, err == nil && FindNodeByTag(parsed, "html") != nil)
+}
@@ -23,8 +23,10 @@ type (
 )
 
 const (
-	ElementNode = html.ElementNode
-	TextNode    = html.TextNode
+	ElementNode  = html.ElementNode
+	TextNode     = html.TextNode
+	CommentNode  = html.CommentNode
+	DocumentNode = html.DocumentNode
 )
 
 var whitespaceRegexp = regexp.MustCompile("\\s+")
@@ -52,6 +54,14 @@ func Comment(data string) *Node {
 	return &Node{Type: html.CommentNode, Data: data}
 }
 
+// Return the document type declaration of XHTML 1.1.
+func XHTML11Doctype() *Node {
+	return &Node{Type: html.DoctypeNode, Data: "html", Attr: []Attribute{
+		{Key: "public", Val: "-//W3C//DTD XHTML 1.1//EN"},
+		{Key: "system", Val: "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd"},
+	}}
+}
+
 // Return a HTML node with the given text.
 func Text(data string) *Node {
 	return &Node{Type: html.TextNode, Data: data}
@@ -168,6 +178,7 @@ var xhtmlattribs = map[string]struct{}{
 	"id":         struct{}{},
 	"lang":       struct{}{},
 	"name":       struct{}{},
+	"rel":        struct{}{},
 	"src":        struct{}{},
 	"style":      struct{}{},
 	"title":      struct{}{},
@@ -195,7 +206,18 @@ func renderXHTML(w *checkedWriter, node *Node, xhtml bool) {
 	switch node.Type {
 	case html.DoctypeNode:
 		if node.Data == "html" {
-			w.WriteString("<!DOCTYPE html>")
+			w.WriteString("<!DOCTYPE html")
+			public, system := GetAttribute(node, "public"), GetAttribute(node, "system")
+			if public != "" {
+				w.WriteString(" PUBLIC \"" + public + "\"")
+			}
+			if system != "" {
+				if public == "" {
+					w.WriteString(" SYSTEM")
+				}
+				w.WriteString(" \"" + system + "\"")
+			}
+			w.Write([]byte{'>'})
 		}
 	case html.DocumentNode:
 		for c := node.FirstChild; c != nil; c = c.NextSibling {
@@ -337,6 +359,24 @@ func TextBytes(root *Node) int {
 	}
 }
 
+// Return a deep copy of the node and its descendants, with no parent.
+func Clone(node *Node) *Node {
+	if node == nil {
+		return nil
+	}
+	result := &Node{
+		Type:      node.Type,
+		DataAtom:  node.DataAtom,
+		Data:      node.Data,
+		Namespace: node.Namespace,
+		Attr:      append([]Attribute(nil), node.Attr...),
+	}
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		result.AppendChild(Clone(c))
+	}
+	return result
+}
+
 // Remove a node from its parent.
 func Remove(node *Node) *Node {
 	if node != nil && node.Parent != nil {
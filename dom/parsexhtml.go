@@ -0,0 +1,80 @@
+package dom
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/html"
+)
+
+const xmlNamespaceUrl = "http://www.w3.org/XML/1998/namespace"
+
+// Parse an XHTML (or other XML) document into a tree of Nodes.  Unlike
+// `Parse`, this respects self-closing tags such as `<a id="x"/>`.  Namespace
+// prefixes are preserved in `Attribute.Namespace` (e.g. `epub:type`), so that
+// the tree can be rendered again with `RenderXHTMLDoc`.  HTML entities are
+// accepted.  Returns a DocumentNode.
+func ParseXHTML(source io.Reader) (*Node, error) {
+	decoder := xml.NewDecoder(source)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	doc := &Node{Type: html.DocumentNode}
+	prefixes := []map[string]string{{xmlNamespaceUrl: "xml"}}
+	node := doc
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return doc, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string, len(prefixes[len(prefixes)-1]))
+			for k, v := range prefixes[len(prefixes)-1] {
+				scope[k] = v
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" {
+					scope[attr.Value] = attr.Name.Local
+				}
+			}
+			prefixes = append(prefixes, scope)
+			elem := &Node{Type: html.ElementNode, Data: t.Name.Local}
+			for _, attr := range t.Attr {
+				a := Attribute{Key: attr.Name.Local, Val: attr.Value}
+				if attr.Name.Space == "xmlns" {
+					a.Namespace = "xmlns"
+				} else if attr.Name.Space != "" {
+					if prefix, ok := scope[attr.Name.Space]; ok {
+						a.Namespace = prefix
+					} else {
+						a.Namespace = attr.Name.Space
+					}
+				}
+				elem.Attr = append(elem.Attr, a)
+			}
+			node.AppendChild(elem)
+			node = elem
+		case xml.EndElement:
+			if node == doc {
+				return doc, fmt.Errorf("unexpected end element %q", t.Name.Local)
+			}
+			prefixes = prefixes[:len(prefixes)-1]
+			node = node.Parent
+		case xml.CharData:
+			if node != doc {
+				node.AppendChild(Text(string(t)))
+			}
+		case xml.Comment:
+			node.AppendChild(&Node{Type: html.CommentNode, Data: string(t)})
+		}
+	}
+	return doc, nil
+}
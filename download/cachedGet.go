@@ -3,6 +3,7 @@
 package download
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
@@ -27,8 +28,28 @@ var (
 	cacheDir     string
 )
 
-// Fetch the content of a URL, using a cache if possible and if force is false.
-func GetUrl(url, ref string, force bool) (io.ReadCloser, error) {
+// Returned by GetUrl when the server does not return "200 OK".
+type StatusError struct {
+	Url        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %q returned %q (not \"200 OK\")", e.Url, e.Status)
+}
+
+// Return true if a later request might succeed, such as after "429 Too Many
+// Requests" or "503 Service Unavailable".
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+func cachePath(url string) string {
 	cacheDirOnce.Do(func() {
 		cache, err := os.UserCacheDir()
 		if err != nil {
@@ -37,13 +58,31 @@ func GetUrl(url, ref string, force bool) (io.ReadCloser, error) {
 		cacheDir = cache + "/urlcache"
 	})
 	uhashbytes := md5.Sum([]byte(url))
-	uhash := hex.EncodeToString(uhashbytes[:])
-	cache := cacheDir + "/" + uhash
+	return cacheDir + "/" + hex.EncodeToString(uhashbytes[:])
+}
+
+// Return true if the content of the URL is in the cache used by GetUrl.
+func IsCached(url string) bool {
+	return exists(cachePath(url))
+}
+
+// Fetch the content of a URL, using a cache if possible and if force is false.
+func GetUrl(url, ref string, force bool) (io.ReadCloser, error) {
+	return GetUrlContext(context.Background(), url, ref, force)
+}
+
+// Like GetUrl, but the request is cancelled if `ctx` is done.  If the server
+// does not return "200 OK", the error is a *StatusError.
+func GetUrlContext(ctx context.Context, url, ref string, force bool) (io.ReadCloser, error) {
+	cache := cachePath(url)
 	if force || !exists(cache) {
 		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
 			return nil, err
 		}
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
 		if ref != "" {
 			req.Header.Add("Referer", ref)
 		}
@@ -55,10 +94,11 @@ func GetUrl(url, ref string, force bool) (io.ReadCloser, error) {
 		if err != nil {
 			return nil, err
 		}
+		defer resp.Body.Close()
 		if resp.StatusCode != 200 {
-			return nil, fmt.Errorf("GET %q returned %q (not \"200 OK\")", url, resp.Status)
+			return nil, &StatusError{Url: url, StatusCode: resp.StatusCode, Status: resp.Status}
 		}
-		if err = os.WriteFile(cacheDir+"/"+uhash+"_type",
+		if err = os.WriteFile(cache+"_type",
 			[]byte(resp.Header.Get("Content-Type")), 0o644); err != nil {
 			return nil, err
 		}
@@ -67,11 +107,13 @@ func GetUrl(url, ref string, force bool) (io.ReadCloser, error) {
 			return nil, err
 		}
 		_, err = io.Copy(bodyWriter, resp.Body)
+		if closeErr := bodyWriter.Close(); err == nil {
+			err = closeErr
+		}
 		if err != nil {
+			os.Remove(cache) // Do not cache a partial download.
 			return nil, err
 		}
-		resp.Body.Close()
-		bodyWriter.Close()
 	}
 	return os.Open(cache)
 }
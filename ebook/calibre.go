@@ -1,10 +1,19 @@
 package ebook
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func copyFile(src, dst string) error {
@@ -22,22 +31,198 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-// Convert a html file to an epub, using `ebook-convert`.
+// Settings for `ConvertToEbookContext`.  The zero value gives the defaults.
+type ConvertOptions struct {
+	// The converter to run, with the command line of `ebook-convert`.  If
+	// empty, `ebook-convert` is found in the PATH.
+	Converter string
+	// Passed to the converter after the input, output, and chapter arguments.
+	Arguments []string
+	// If positive, the conversion is cancelled after this long.
+	Timeout time.Duration
+	// If not nil, called for each progress report of the converter, such as
+	// "34% Running transforms on e-book...".
+	Progress func(percent int, message string)
+	// If not nil, the output of the converter, with its standard error, is
+	// copied here as it runs.
+	Output io.Writer
+	// The extension giving the format of the input, such as ".html".  If
+	// empty, the format is found from the name or content of the input.
+	InputFormat string
+}
+
+// The error returned when the converter fails.
+type ConvertError struct {
+	Err    error  // The error from running the converter.
+	Output string // The end of the converter's output.
+}
+
+func (e *ConvertError) Error() string {
+	if e.Output == "" {
+		return "ebook-convert: " + e.Err.Error()
+	}
+	return fmt.Sprintf("ebook-convert: %v:\n%s", e.Err, e.Output)
+}
+
+func (e *ConvertError) Unwrap() error { return e.Err }
+
+// The amount of the converter's output kept for a ConvertError.
+const convertErrorOutputSize = 4096
+
+var progressRegexp = regexp.MustCompile(`^\s*([0-9]+)% (.*)$`)
+
+// Input formats that the converter recognizes by file extension.
+var convertInputExtensions = map[string]bool{
+	".azw3": true, ".docx": true, ".epub": true, ".fb2": true, ".htm": true, ".html": true,
+	".md": true, ".mobi": true, ".odt": true, ".pdf": true, ".rtf": true, ".txt": true,
+	".txtz": true, ".xhtml": true, ".zip": true,
+}
+
+// Return the file extension that tells the converter the format of `src`.
+// If the name of `src` does not tell, the content is examined.
+func inputExtension(src string) (string, error) {
+	if ext := strings.ToLower(filepath.Ext(src)); convertInputExtensions[ext] {
+		return ext, nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) && bytes.Contains(head, []byte("mimetypeapplication/epub+zip")):
+		return ".epub", nil
+	case bytes.Contains(head, []byte("<FictionBook")):
+		return ".fb2", nil
+	}
+	mediaType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	switch mediaType {
+	case "text/html":
+		return ".html", nil
+	case "text/xml":
+		if bytes.Contains(head, []byte("<html")) {
+			return ".xhtml", nil
+		}
+	case "text/plain":
+		return ".txt", nil
+	case "application/pdf":
+		return ".pdf", nil
+	}
+	return "", fmt.Errorf("%s: unknown input format", src)
+}
+
+// Convert a html file to an epub, using `ebook-convert`.  The output of the
+// converter, with its standard error, is copied to standard output.
 func ConvertToEbook(src, dst string, arguments ...string) error {
-	tmpDir, err := os.MkdirTemp("", "")
+	return ConvertToEbookContext(context.Background(), src, dst, ConvertOptions{
+		Arguments:   arguments,
+		Output:      os.Stdout,
+		InputFormat: ".html",
+	})
+}
+
+// Convert an ebook to another format, using `ebook-convert`.  The format of
+// `src` is given by `options.InputFormat`, or found from its name or content;
+// the format of `dst` is given by its extension.  The converter, and any
+// processes it started, are killed if `ctx` is done.  If the converter fails,
+// the error is a *ConvertError.
+func ConvertToEbookContext(ctx context.Context, src, dst string, options ConvertOptions) error {
+	if filepath.Ext(dst) == "" {
+		return fmt.Errorf("%s: no file extension to give the output format", dst)
+	}
+	ext := options.InputFormat
+	if ext == "" {
+		var err error
+		if ext, err = inputExtension(src); err != nil {
+			return err
+		}
+	}
+	tmpDir, err := os.MkdirTemp("", "ebook-convert")
 	if err != nil {
 		return err
 	}
-	tmpPath := filepath.Join(tmpDir, "book.html")
-	err = copyFile(src, tmpPath)
-	if err != nil {
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, "book"+ext)
+	if err = copyFile(src, tmpPath); err != nil {
 		return err
 	}
-	defer os.RemoveAll(tmpPath)
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+	converter := options.Converter
+	if converter == "" {
+		converter = "ebook-convert"
+	}
 	args := append([]string{tmpPath, dst,
-		"--chapter", "//*[@class=\"chapter\"]"}, arguments...)
+		"--chapter", "//*[@class=\"chapter\"]"}, options.Arguments...)
 
-	convert := exec.Command("ebook-convert", args...)
-	convert.Stdout, convert.Stderr = os.Stdout, os.Stderr
-	return convert.Run()
+	convert := exec.Command(converter, args...)
+	setProcessGroup(convert)
+	// With an *os.File, Wait does not wait for processes started by the
+	// converter to close their copies of the pipe.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer pr.Close()
+	convert.Stdout, convert.Stderr = pw, pw
+	err = convert.Start()
+	pw.Close()
+	if err != nil {
+		return &ConvertError{Err: err}
+	}
+	finished := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			killProcessGroup(convert)
+			pr.Close() // Stop reading, even if the pipe is still open.
+		case <-finished:
+		}
+	}()
+
+	var output []byte
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if options.Output != nil {
+			options.Output.Write(line)
+			options.Output.Write([]byte{'\n'})
+		}
+		output = append(output, line...)
+		output = append(output, '\n')
+		if len(output) > 2*convertErrorOutputSize {
+			output = append(output[:0], output[len(output)-convertErrorOutputSize:]...)
+		}
+		if m := progressRegexp.FindSubmatch(line); m != nil && options.Progress != nil {
+			percent, _ := strconv.Atoi(string(m[1]))
+			options.Progress(percent, string(m[2]))
+		}
+	}
+	io.Copy(io.Discard, pr) // In case of a very long line.
+	// Stop watching before the child is reaped, so that its process group is
+	// never killed after its ID may have been reused.
+	close(finished)
+	<-watcherDone
+	if err = convert.Wait(); err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		if len(output) > convertErrorOutputSize {
+			output = output[len(output)-convertErrorOutputSize:]
+		}
+		return &ConvertError{Err: err, Output: strings.TrimSpace(string(output))}
+	}
+	return nil
 }
@@ -0,0 +1,120 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/expect"
+)
+
+// Write a shell script that pretends to be `ebook-convert`.
+func writeFakeConverter(t *testing.T, dir, script string) string {
+	path := filepath.Join(dir, "fake-convert")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConvertToEbook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "input")
+	if err := os.WriteFile(src, []byte("<!DOCTYPE html><html><body>hi</body></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "output.epub")
+
+	converter := writeFakeConverter(t, dir, `
+echo "$1" > "`+dir+`/input-path"
+echo "Conversion options changed from defaults:"
+echo "1% Converting input to HTML..."
+echo "34% Running transforms on e-book..."
+cp "$1" "$2"
+shift 2
+echo "$@" > "`+dir+`/arguments"
+echo "100% Done"
+`)
+	var progress []string
+	err := ConvertToEbookContext(context.Background(), src, dst, ConvertOptions{
+		Converter: converter,
+		Arguments: []string{"--title", "T"},
+		Progress: func(percent int, message string) {
+			progress = append(progress, strings.Repeat("#", percent/10)+message)
+		},
+	})
+	expect.True(t, err == nil)
+	expect.DeepEqual(t, []string{"Converting input to HTML...", "###Running transforms on e-book...", "##########Done"}, progress)
+	data, _ := os.ReadFile(dst)
+	expect.True(t, strings.Contains(string(data), "<body>hi</body>"))
+	arguments, _ := os.ReadFile(filepath.Join(dir, "arguments"))
+	expect.Equal(t, "--chapter //*[@class=\"chapter\"] --title T\n", string(arguments))
+	inputPath, _ := os.ReadFile(filepath.Join(dir, "input-path"))
+	expect.Equal(t, "book.html", filepath.Base(strings.TrimSpace(string(inputPath))))
+	_, err = os.Stat(filepath.Dir(strings.TrimSpace(string(inputPath))))
+	expect.True(t, os.IsNotExist(err))
+
+	converter = writeFakeConverter(t, dir, "echo 'ValueError: bad input' >&2\nexit 2\n")
+	err = ConvertToEbookContext(context.Background(), src, dst, ConvertOptions{Converter: converter})
+	var convertError *ConvertError
+	if expect.True(t, errors.As(err, &convertError)) {
+		expect.Equal(t, "ValueError: bad input", convertError.Output)
+		expect.True(t, strings.Contains(err.Error(), "exit status 2"))
+	}
+
+	converter = writeFakeConverter(t, dir, "exec sleep 10\n")
+	start := time.Now()
+	err = ConvertToEbookContext(context.Background(), src, dst, ConvertOptions{
+		Converter: converter,
+		Timeout:   50 * time.Millisecond,
+	})
+	expect.True(t, errors.Is(err, context.DeadlineExceeded))
+	expect.True(t, time.Since(start) < 5*time.Second)
+
+	// A process started by the converter holds its output open.
+	converter = writeFakeConverter(t, dir, "echo started\nsleep 3\necho done\n")
+	var output strings.Builder
+	start = time.Now()
+	err = ConvertToEbookContext(context.Background(), src, dst, ConvertOptions{
+		Converter: converter,
+		Timeout:   200 * time.Millisecond,
+		Output:    &output,
+	})
+	expect.True(t, errors.Is(err, context.DeadlineExceeded))
+	expect.True(t, time.Since(start) < 2*time.Second)
+	expect.Equal(t, "started\n", output.String())
+
+	err = ConvertToEbookContext(context.Background(), src, filepath.Join(dir, "output"), ConvertOptions{Converter: converter})
+	expect.True(t, err != nil && !errors.As(err, &convertError))
+}
+
+func TestConvertToEbookLegacy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$1\" > \"" + dir + "/input-path\"\ncp \"$1\" \"$2\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ebook-convert"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	// Without a doctype or html element, the content would be detected as text.
+	src := filepath.Join(dir, "input.tmp")
+	if err := os.WriteFile(src, []byte(`<meta charset="utf-8"><section>hi</section>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expect.True(t, ConvertToEbook(src, filepath.Join(dir, "output.epub")) == nil)
+	inputPath, _ := os.ReadFile(filepath.Join(dir, "input-path"))
+	expect.Equal(t, "book.html", filepath.Base(strings.TrimSpace(string(inputPath))))
+}
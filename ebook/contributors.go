@@ -0,0 +1,117 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// MARC relator codes, for `Contributor.Role`.
+const (
+	RoleAuthor      = "aut"
+	RoleContributor = "ctb"
+	RoleEditor      = "edt"
+	RoleIllustrator = "ill"
+	RoleTranslator  = "trl"
+)
+
+var roleNames = map[string]string{
+	RoleAuthor:      "Author",
+	RoleContributor: "Contributor",
+	RoleEditor:      "Editor",
+	RoleIllustrator: "Illustrator",
+	RoleTranslator:  "Translator",
+}
+
+// A person who contributed to a book.
+type Contributor struct {
+	Name   string
+	Role   string // A MARC relator code, such as RoleAuthor.  Empty means RoleAuthor.
+	FileAs string // The name used for sorting, such as "Austen, Jane".  Optional.
+}
+
+func (c Contributor) role() string {
+	if c.Role == "" {
+		return RoleAuthor
+	}
+	return c.Role
+}
+
+// Return a human-readable name for the contributor's role.
+func (c Contributor) RoleName() string {
+	if name, ok := roleNames[c.role()]; ok {
+		return name
+	}
+	return c.role()
+}
+
+// Return `Contributors`, or if that is empty, `Authors` as a single author.
+func (info EbookInfo) AllContributors() []Contributor {
+	if len(info.Contributors) > 0 {
+		return info.Contributors
+	}
+	if info.Authors == "" {
+		return nil
+	}
+	return []Contributor{{Name: info.Authors, Role: RoleAuthor}}
+}
+
+// Return the names of the authors in `Contributors`, or if that is empty,
+// `Authors`, matching `AllContributors`.
+func (info EbookInfo) AuthorNames() string {
+	if len(info.Contributors) > 0 {
+		return strings.Join(contributorNames(info.Contributors, RoleAuthor), ", ")
+	}
+	return info.Authors
+}
+
+// Return the dc:creator and dc:contributor elements for the package document,
+// and the meta elements that refine them.
+func contributorMetadata(info EbookInfo) ([]xmlMetaItems, []xmlMetaProperty) {
+	var items []xmlMetaItems
+	var properties []xmlMetaProperty
+	for i, c := range info.AllContributors() {
+		id := fmt.Sprintf("creator%02d", i+1)
+		tag := "dc:contributor"
+		if c.role() == RoleAuthor {
+			tag = "dc:creator"
+		}
+		items = append(items, xmlMetaItems{
+			XMLName:    xml.Name{Local: tag},
+			Value:      c.Name,
+			Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "id"}, Value: id}},
+		})
+		properties = append(properties,
+			xmlMetaProperty{Refines: "#" + id, Property: "role", Scheme: "marc:relators", Value: c.role()})
+		if c.FileAs != "" {
+			properties = append(properties, xmlMetaProperty{Refines: "#" + id, Property: "file-as", Value: c.FileAs})
+		}
+	}
+	return items, properties
+}
+
+// Return the names of the contributors with the given role.
+func contributorNames(contributors []Contributor, role string) []string {
+	var names []string
+	for _, c := range contributors {
+		if c.role() == role {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// Return a line for each contributor who is not an author, such as
+// "Translator: Jane Doe".
+func otherContributors(info EbookInfo) []string {
+	var lines []string
+	for _, c := range info.AllContributors() {
+		if c.role() != RoleAuthor {
+			lines = append(lines, c.RoleName()+": "+c.Name)
+		}
+	}
+	return lines
+}
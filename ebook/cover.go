@@ -0,0 +1,186 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The size of the covers made by `GenerateCover`.
+const (
+	GeneratedCoverWidth  = 600
+	GeneratedCoverHeight = 900
+)
+
+// Return a JPEG cover showing the title, authors, and series of the book, on
+// a background whose color is derived from the title.
+func (info EbookInfo) GenerateCover() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, GeneratedCoverWidth, GeneratedCoverHeight))
+	sum := sha256.Sum256([]byte(info.Title))
+	hue := float64(int(sum[0])<<8|int(sum[1])) / 65536
+	background := hsvColor(hue, 0.5, 0.45)
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	frame := hsvColor(hue, 0.3, 0.75)
+	const margin, line = 24, 4
+	for _, r := range []image.Rectangle{
+		image.Rect(margin, margin, GeneratedCoverWidth-margin, margin+line),
+		image.Rect(margin, GeneratedCoverHeight-margin-line, GeneratedCoverWidth-margin, GeneratedCoverHeight-margin),
+		image.Rect(margin, margin, margin+line, GeneratedCoverHeight-margin),
+		image.Rect(GeneratedCoverWidth-margin-line, margin, GeneratedCoverWidth-margin, GeneratedCoverHeight-margin),
+	} {
+		draw.Draw(img, r, image.NewUniform(frame), image.Point{}, draw.Src)
+	}
+
+	const textWidth = GeneratedCoverWidth - 4*margin
+	title := info.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	// Shrink the title until it fits in the top half.
+	var titleFace font.Face
+	var titleLines []string
+	for size := 64.0; ; size -= 4 {
+		face, err := coverFace(gobold.TTF, size)
+		if err != nil {
+			return nil, err
+		}
+		titleFace, titleLines = face, wrapCoverText(face, title, textWidth)
+		if size <= 24 || len(titleLines)*face.Metrics().Height.Ceil() <= GeneratedCoverHeight/2 {
+			break
+		}
+	}
+	y := drawCoverText(img, titleFace, titleLines, GeneratedCoverHeight/5, color.White)
+
+	if info.Series != "" {
+		series := info.Series
+		if info.SeriesIndex != 0 {
+			series += " #" + formatSeriesIndex(info.SeriesIndex)
+		}
+		face, err := coverFace(goitalic.TTF, 28)
+		if err != nil {
+			return nil, err
+		}
+		drawCoverText(img, face, wrapCoverText(face, series, textWidth), y+face.Metrics().Height.Ceil(), frame)
+	}
+
+	if authors := info.AuthorNames(); authors != "" {
+		face, err := coverFace(goregular.TTF, 32)
+		if err != nil {
+			return nil, err
+		}
+		lines := wrapCoverText(face, authors, textWidth)
+		if len(lines) > 3 {
+			lines = append(lines[:2], "…")
+		}
+		top := GeneratedCoverHeight - 3*margin - len(lines)*face.Metrics().Height.Ceil()
+		drawCoverText(img, face, lines, top, color.White)
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Return the cover, or if there is none, a generated cover.  Errors are logged.
+func (info EbookInfo) coverOrGenerated() []byte {
+	if len(info.Cover) > 0 {
+		return info.Cover
+	}
+	cover, err := info.GenerateCover()
+	if err != nil {
+		log.Printf("Cover error: %v", err)
+		return nil
+	}
+	return cover
+}
+
+func coverFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// Break `text` into lines no wider than `width` pixels.  Words that are too
+// wide are broken between characters.
+func wrapCoverText(face font.Face, text string, width int) []string {
+	var lines []string
+	var line string
+	fits := func(s string) bool { return font.MeasureString(face, s).Ceil() <= width }
+	for _, word := range strings.Fields(text) {
+		if line != "" && fits(line+" "+word) {
+			line += " " + word
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+		for _, r := range word {
+			if line != "" && !fits(line+string(r)) {
+				lines = append(lines, line)
+				line = ""
+			}
+			line += string(r)
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Draw the lines centered, starting at `top`.  Return the bottom of the text.
+func drawCoverText(img draw.Image, face font.Face, lines []string, top int, c color.Color) int {
+	metrics := face.Metrics()
+	drawer := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	y := top
+	for _, line := range lines {
+		x := (img.Bounds().Dx() - drawer.MeasureString(line).Ceil()) / 2
+		drawer.Dot = fixed.P(x, y+metrics.Ascent.Ceil())
+		drawer.DrawString(line)
+		y += metrics.Height.Ceil()
+	}
+	return y
+}
+
+// Convert a color from hue, saturation and value, each in [0, 1).
+func hsvColor(h, s, v float64) color.RGBA {
+	i := int(h * 6)
+	f := h*6 - float64(i)
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch i % 6 {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA{uint8(r * 255), uint8(g * 255), uint8(b * 255), 255}
+}
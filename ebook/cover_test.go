@@ -0,0 +1,64 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"image/jpeg"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/expect"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestGenerateCover(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Cover = nil
+	book.Series = "A Very Long Series Name That Needs Wrapping"
+	book.SeriesIndex = 2
+	cover, err := book.GenerateCover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(cover))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, GeneratedCoverWidth, img.Bounds().Dx())
+	expect.Equal(t, GeneratedCoverHeight, img.Bounds().Dy())
+	again, _ := book.GenerateCover()
+	expect.True(t, bytes.Equal(cover, again))
+	book.Title += "!"
+	other, _ := book.GenerateCover()
+	expect.True(t, !bytes.Equal(cover, other))
+
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	expect.True(t, entries["book/cover.jpg"] != "")
+
+	buffer.Reset()
+	if err := book.WriteHtml(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expect.True(t, strings.Contains(buffer.String(), "data:image/jpeg;base64,"))
+}
+
+func TestWrapCoverText(t *testing.T) {
+	face, err := coverFace(goregular.TTF, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := wrapCoverText(face, "one two three four five six seven eight nine ten", 200)
+	expect.True(t, len(lines) > 1)
+	expect.Equal(t, "one two three four five six seven eight nine ten", strings.Join(lines, " "))
+	for _, line := range wrapCoverText(face, strings.Repeat("x", 100), 200) {
+		expect.True(t, len(line) < 100)
+	}
+}
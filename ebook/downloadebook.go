@@ -4,7 +4,13 @@ package ebook
 // Use of this program is governed by the file LICENSE.
 
 import (
+	"context"
 	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -16,27 +22,228 @@ type EbookGeneratorFunction func(url string, doPopulate bool) (EbookInfo, error)
 // Returned by a EbookGeneratorFunction when the URL can not be handled.
 var UnsupportedUrlError = errors.New("unsupported url")
 
+// How a generator uses cached downloads.
+type CachePolicy int
+
+const (
+	// Use cached copies of every page.
+	CacheUse CachePolicy = iota
+	// Download the title page again, but use cached copies of chapters.
+	CacheRefreshIndex
+	// Download every page again.
+	CacheRefreshAll
+)
+
+// Settings for `EbookGenerator.GenerateEbook`.  The zero value gives the
+// metadata of the book from cached pages.
+type GeneratorOptions struct {
+	// If true, download and populate the entire EbookInfo data structure, not
+	// just its metadata.
+	Populate bool
+	Cache    CachePolicy
+	// The chapters to download, counting from one.  Zero means the first or
+	// the last chapter.
+	FirstChapter, LastChapter int
+	// If not nil, called as chapters are downloaded, with the number of
+	// chapters done and the total, if known, or zero.
+	Progress func(done, total int, message string)
+}
+
+// Return true if the pages should be downloaded again rather than read from
+// the cache.  `index` is true for the title page and other pages that list
+// the chapters.
+func (o GeneratorOptions) ForceDownload(index bool) bool {
+	return o.Cache == CacheRefreshAll || (index && o.Cache == CacheRefreshIndex)
+}
+
+// Return true if chapter `i`, counting from zero, is in the range of
+// chapters to download.
+func (o GeneratorOptions) IncludesChapter(i int) bool {
+	return (o.FirstChapter <= 0 || i+1 >= o.FirstChapter) && (o.LastChapter <= 0 || i+1 <= o.LastChapter)
+}
+
+// Call `o.Progress`, if it is set.
+func (o GeneratorOptions) ReportProgress(done, total int, message string) {
+	if o.Progress != nil {
+		o.Progress(done, total, message)
+	}
+}
+
+// Generates an ebook from the URL of the title page of the book.  Returns
+// UnsupportedUrlError if the URL can not be handled.  Generators should stop
+// and return `ctx.Err()` when `ctx` is done.
+type EbookGenerator interface {
+	GenerateEbook(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error)
+}
+
+// An adapter to use a function as an EbookGenerator.
+type EbookGeneratorContextFunction func(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error)
+
+func (fn EbookGeneratorContextFunction) GenerateEbook(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error) {
+	return fn(ctx, url, options)
+}
+
+// Adapt an EbookGeneratorFunction to the EbookGenerator interface.  It can not
+// be cancelled once started, it ignores the cache policy, and chapters
+// outside the range are dropped after downloading.
+func (fn EbookGeneratorFunction) GenerateEbook(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return EbookInfo{}, err
+	}
+	info, err := fn(url, options.Populate)
+	if err != nil {
+		return info, err
+	}
+	if options.FirstChapter > 0 || options.LastChapter > 0 {
+		var chapters []Chapter
+		for i, chapter := range info.Chapters {
+			if options.IncludesChapter(i) {
+				chapters = append(chapters, chapter)
+			}
+		}
+		info.Chapters = chapters
+	}
+	if options.Populate {
+		options.ReportProgress(len(info.Chapters), len(info.Chapters), info.Title)
+	}
+	return info, ctx.Err()
+}
+
+// A generator with the URLs it handles, for `RegisterNamedGenerator`.
+type NamedGenerator struct {
+	Name        string
+	Description string
+	// If not empty, the host names of the URLs handled.  A name starting
+	// with "*." also matches the subdomains of the rest of the name.
+	Hosts []string
+	// If not empty, the URLs handled must match one of these.
+	Patterns []*regexp.Regexp
+	// Generators with higher priorities are tried first; those with equal
+	// priorities are tried in the order they were registered.
+	Priority  int
+	Generator EbookGenerator
+}
+
+// Return true if the generator may handle the URL, judging by its Hosts and
+// Patterns.
+func (g NamedGenerator) Matches(rawUrl string) bool {
+	if len(g.Hosts) > 0 {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(u.Hostname())
+		found := false
+		for _, h := range g.Hosts {
+			h = strings.ToLower(h)
+			if strings.HasPrefix(h, "*.") {
+				suffix := h[2:]
+				found = host == suffix || strings.HasSuffix(host, "."+suffix)
+			} else {
+				found = host == h
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(g.Patterns) == 0 {
+		return true
+	}
+	for _, pattern := range g.Patterns {
+		if pattern.MatchString(rawUrl) {
+			return true
+		}
+	}
+	return false
+}
+
 var (
-	registerdFunctions      []EbookGeneratorFunction
+	registerdFunctions      []NamedGenerator
 	registerdFunctionsMutex sync.Mutex
 )
 
-// Register the given function.
+// Register the given function.  It is tried for every URL.
 func RegisterEbookGenerator(downloadFunction EbookGeneratorFunction) {
+	RegisterGenerator(downloadFunction)
+}
+
+// Register the given generator.  It is tried for every URL.
+func RegisterGenerator(generator EbookGenerator) {
+	RegisterNamedGenerator(NamedGenerator{Generator: generator})
+}
+
+// Register the given generator.  Panics if the name is already registered or
+// there is no Generator.
+func RegisterNamedGenerator(generator NamedGenerator) {
+	if generator.Generator == nil {
+		panic("ebook: RegisterNamedGenerator: no Generator for " + strconv.Quote(generator.Name))
+	}
+	if err := registerNamedGenerator(generator); err != nil {
+		panic("ebook: RegisterNamedGenerator: " + err.Error())
+	}
+}
+
+// Add the generator to the registry, or return an error if its name is
+// already registered.
+func registerNamedGenerator(generator NamedGenerator) error {
 	registerdFunctionsMutex.Lock()
-	registerdFunctions = append(registerdFunctions, downloadFunction)
-	registerdFunctionsMutex.Unlock()
+	defer registerdFunctionsMutex.Unlock()
+	for _, g := range registerdFunctions {
+		if generator.Name != "" && g.Name == generator.Name {
+			return errors.New("duplicate name " + strconv.Quote(generator.Name))
+		}
+	}
+	// Copy, so that slices returned earlier are not changed.
+	generators := append([]NamedGenerator{}, registerdFunctions...)
+	generators = append(generators, generator)
+	sort.SliceStable(generators, func(i, j int) bool {
+		return generators[i].Priority > generators[j].Priority
+	})
+	registerdFunctions = generators
+	return nil
+}
+
+// Return the registered generators, in the order they are tried.
+func ListEbookGenerators() []NamedGenerator {
+	registerdFunctionsMutex.Lock()
+	defer registerdFunctionsMutex.Unlock()
+	return append([]NamedGenerator{}, registerdFunctions...)
+}
+
+// Return the first generator that `DownloadEbook` would try for the URL,
+// without calling it.  Generators registered without Hosts or Patterns match
+// every URL, but may still decline it when called.
+func GeneratorFor(url string) (NamedGenerator, bool) {
+	for _, g := range ListEbookGenerators() {
+		if g.Matches(url) {
+			return g, true
+		}
+	}
+	return NamedGenerator{}, false
 }
 
-// Return the result of the first registered download function that does not return UnsupportedUrlError.
+// Return the result of the first registered download function that matches the URL and does not return UnsupportedUrlError.
 // @param url - the URL of the title page of the book.
 // @param doPopulate - if true, download and populate the entire EbookInfo data structure, not just its metadata.
 func DownloadEbook(url string, doPopulate bool) (EbookInfo, error) {
-	registerdFunctionsMutex.Lock()
-	fns := registerdFunctions
-	registerdFunctionsMutex.Unlock()
-	for _, fn := range fns {
-		info, err := fn(url, doPopulate)
+	return DownloadEbookContext(context.Background(), url, GeneratorOptions{Populate: doPopulate})
+}
+
+// Return the result of the first registered generator that matches the URL
+// and does not return UnsupportedUrlError.
+func DownloadEbookContext(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error) {
+	for _, generator := range ListEbookGenerators() {
+		if !generator.Matches(url) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return EbookInfo{}, err
+		}
+		info, err := generator.Generator.GenerateEbook(ctx, url, options)
 		if err != UnsupportedUrlError {
 			return info, err
 		}
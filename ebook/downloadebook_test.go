@@ -0,0 +1,150 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestDownloadEbookContext(t *testing.T) {
+	registerdFunctionsMutex.Lock()
+	saved := registerdFunctions
+	registerdFunctions = nil
+	registerdFunctionsMutex.Unlock()
+	defer func() {
+		registerdFunctionsMutex.Lock()
+		registerdFunctions = saved
+		registerdFunctionsMutex.Unlock()
+	}()
+
+	RegisterEbookGenerator(func(url string, doPopulate bool) (EbookInfo, error) {
+		if !strings.HasPrefix(url, "https://old.example/") {
+			return EbookInfo{}, UnsupportedUrlError
+		}
+		info := EbookInfo{Title: "Old"}
+		if doPopulate {
+			info.Chapters = []Chapter{{Title: "1"}, {Title: "2"}, {Title: "3"}}
+		}
+		return info, nil
+	})
+	RegisterGenerator(EbookGeneratorContextFunction(
+		func(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error) {
+			if !strings.HasPrefix(url, "https://new.example/") {
+				return EbookInfo{}, UnsupportedUrlError
+			}
+			expect.True(t, options.ForceDownload(true))
+			expect.True(t, !options.ForceDownload(false))
+			info := EbookInfo{Title: "New"}
+			for i := 0; i < 4; i++ {
+				if err := ctx.Err(); err != nil {
+					return info, err
+				}
+				if options.IncludesChapter(i) {
+					info.Chapters = append(info.Chapters, Chapter{Title: string(rune('1' + i))})
+				}
+				options.ReportProgress(i+1, 4, "")
+			}
+			return info, nil
+		}))
+
+	info, err := DownloadEbook("https://old.example/book", false)
+	expect.True(t, err == nil)
+	expect.Equal(t, "Old", info.Title)
+	expect.Equal(t, 0, len(info.Chapters))
+
+	var reports []int
+	options := GeneratorOptions{Populate: true, FirstChapter: 2, Cache: CacheRefreshIndex,
+		Progress: func(done, total int, message string) { reports = append(reports, done) }}
+	info, err = DownloadEbookContext(context.Background(), "https://old.example/book", options)
+	expect.True(t, err == nil)
+	expect.Equal(t, 2, len(info.Chapters))
+	expect.Equal(t, "2", info.Chapters[0].Title)
+	expect.DeepEqual(t, []int{2}, reports)
+
+	reports = nil
+	options.LastChapter = 3
+	info, err = DownloadEbookContext(context.Background(), "https://new.example/book", options)
+	expect.True(t, err == nil)
+	expect.Equal(t, "New", info.Title)
+	expect.Equal(t, 2, len(info.Chapters))
+	expect.Equal(t, "3", info.Chapters[1].Title)
+	expect.DeepEqual(t, []int{1, 2, 3, 4}, reports)
+
+	_, err = DownloadEbook("https://other.example/book", true)
+	expect.True(t, err == UnsupportedUrlError)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DownloadEbookContext(ctx, "https://new.example/book", GeneratorOptions{})
+	expect.True(t, err == context.Canceled)
+}
+
+func TestNamedGenerators(t *testing.T) {
+	registerdFunctionsMutex.Lock()
+	saved := registerdFunctions
+	registerdFunctions = nil
+	registerdFunctionsMutex.Unlock()
+	defer func() {
+		registerdFunctionsMutex.Lock()
+		registerdFunctions = saved
+		registerdFunctionsMutex.Unlock()
+	}()
+
+	var called []string
+	generator := func(name string) EbookGenerator {
+		return EbookGeneratorContextFunction(
+			func(ctx context.Context, url string, options GeneratorOptions) (EbookInfo, error) {
+				called = append(called, name)
+				return EbookInfo{Title: name}, nil
+			})
+	}
+	RegisterNamedGenerator(NamedGenerator{
+		Name:        "site",
+		Description: "A site",
+		Hosts:       []string{"*.site.example"},
+		Generator:   generator("site"),
+	})
+	RegisterNamedGenerator(NamedGenerator{
+		Name:      "books",
+		Hosts:     []string{"site.example"},
+		Patterns:  []*regexp.Regexp{regexp.MustCompile(`^https://[^/]+/book/[0-9]+$`)},
+		Priority:  10,
+		Generator: generator("books"),
+	})
+	RegisterGenerator(generator("fallback"))
+
+	var names []string
+	for _, g := range ListEbookGenerators() {
+		names = append(names, g.Name)
+	}
+	expect.DeepEqual(t, []string{"books", "site", ""}, names)
+
+	for _, tc := range []struct{ url, name string }{
+		{"https://site.example/book/12", "books"},
+		{"https://SITE.example/book/12?page=2", "site"},
+		{"https://www.site.example/book/12", "site"},
+		{"https://notsite.example/book/12", ""},
+	} {
+		g, ok := GeneratorFor(tc.url)
+		expect.True(t, ok)
+		expect.Equal(t, tc.name, g.Name)
+	}
+	expect.Equal(t, 0, len(called))
+
+	info, err := DownloadEbook("https://site.example/book/12", false)
+	expect.True(t, err == nil)
+	expect.Equal(t, "books", info.Title)
+	expect.DeepEqual(t, []string{"books"}, called)
+
+	defer func() {
+		expect.True(t, recover() != nil)
+	}()
+	RegisterNamedGenerator(NamedGenerator{Name: "site", Generator: generator("again")})
+	t.Error("duplicate name did not panic")
+}
@@ -10,68 +10,124 @@ import (
 	"io"
 )
 
-func makePackage(info EbookInfo, uuid string, dst io.Writer, cover bool) error {
+// Write the package document.  If `epub2` is set, it is an OPF 2.0 document
+// without a nav document.
+func makePackage(info EbookInfo, uuid string, toc []*tocEntry, dst io.Writer, cover bool, images []epubImage, fonts []epubFont, parts []int, options EpubOptions) error {
+	epub2 := options.Epub2
 	manifestItems := []xmlItem{
 		xmlItem{Id: "frontmatter", Href: "frontmatter.xhtml", MediaType: "application/xhtml+xml"},
-		xmlItem{Id: "toc", Href: "toc.xhtml", MediaType: "application/xhtml+xml",
-			Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "properties"}, Value: "nav"}}},
-		xmlItem{Id: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"},
 	}
 	itemrefs := []xmlItemref{
 		xmlItemref{Idref: "frontmatter"},
-		xmlItemref{Idref: "toc"},
 	}
+	if !epub2 {
+		manifestItems = append(manifestItems, xmlItem{Id: "toc", Href: "toc.xhtml", MediaType: "application/xhtml+xml",
+			Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "properties"}, Value: "nav"}}})
+		itemrefs = append(itemrefs, xmlItemref{Idref: "toc"})
+	}
+	manifestItems = append(manifestItems,
+		xmlItem{Id: "ncx", Href: "toc.ncx", MediaType: "application/x-dtbncx+xml"},
+		xmlItem{Id: "style", Href: "style.css", MediaType: "text/css"},
+	)
 	if cover {
-		manifestItems = append(manifestItems, xmlItem{Id: "cover", Href: "cover.jpg", MediaType: "image/jpeg",
-			Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "properties"}, Value: "cover-image"}}})
+		item := xmlItem{Id: "cover", Href: "cover.jpg", MediaType: "image/jpeg"}
+		if !epub2 {
+			item.Attributes = []xml.Attr{xml.Attr{Name: xml.Name{Local: "properties"}, Value: "cover-image"}}
+		}
+		manifestItems = append(manifestItems, item)
+	}
+	for i, image := range images {
+		manifestItems = append(manifestItems, xmlItem{Id: fmt.Sprintf("img%04d", i), Href: image.Href, MediaType: image.MediaType})
 	}
-	for i, _ := range info.Chapters {
-		fn := fmt.Sprintf("%04d", i)
-		id := "ch" + fn
+	for i, font := range fonts {
+		manifestItems = append(manifestItems, xmlItem{Id: fmt.Sprintf("font%02d", i), Href: font.Href, MediaType: font.MediaType})
+	}
+	walkToc(toc, func(e *tocEntry) {
+		fn, id := e.fileName()
 		manifestItems = append(manifestItems, xmlItem{Id: id, Href: fn + ".xhtml", MediaType: "application/xhtml+xml"})
 		itemrefs = append(itemrefs, xmlItemref{Idref: id})
+		if e.Chapter >= 0 {
+			for j := 1; j < parts[e.Chapter]; j++ {
+				partId := fmt.Sprintf("%s-%d", id, j)
+				manifestItems = append(manifestItems, xmlItem{Id: partId, Href: chapterPartName(e.Chapter, j) + ".xhtml", MediaType: "application/xhtml+xml"})
+				itemrefs = append(itemrefs, xmlItemref{Idref: partId})
+			}
+		}
+	})
+	modTime := info.Modified
+	if modTime.IsZero() {
+		modTime = info.CalculateLastModified()
 	}
-	modified := info.Modified.UTC().Format("2006-01-02T15:04:05Z")
+	modified := modTime.UTC().Format(epubTimestamp)
 	description := fmt.Sprintf("%s\n\nSOURCE: %s\nCHAPTERS: %d\n", info.Comments, info.Source, len(info.Chapters))
+	creators, refinements := contributorMetadata(info)
+	properties := []xmlMetaProperty{
+		xmlMetaProperty{Property: "dcterms:modified", Value: modified},
+	}
+	properties = append(properties, refinements...)
+	properties = append(properties, seriesMetadata(info)...)
+	properties = append(properties, accessibilityMetadata(cover, len(images) > 0)...)
+	properties = append(properties, xmlMetaProperty{Name: chapterLayoutMeta, Content: options.chapterLayout()})
+	version := "3.0"
+	guide := []xmlGuideReference{
+		xmlGuideReference{Title: "Cover page", Type: "cover", Href: "frontmatter.xhtml"},
+		xmlGuideReference{Title: "Table of content", Type: "toc", Href: "toc.xhtml"},
+	}
+	if epub2 {
+		version = "2.0"
+		creators = contributorMetadata2(info)
+		properties = epub2Properties(properties)
+		if cover {
+			properties = append(properties, xmlMetaProperty{Name: "cover", Content: "cover"})
+		}
+		guide = guide[:1]
+		if len(toc) > 0 {
+			name, _ := toc[0].fileName()
+			guide = append(guide, xmlGuideReference{Title: "Beginning", Type: "text", Href: name + ".xhtml"})
+		}
+	}
+	metaItems := []xmlMetaItems{
+		xmlMetaItems{
+			XMLName:    xml.Name{Local: "dc:identifier"},
+			Value:      uuid,
+			Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "id"}, Value: "BookID"}},
+		},
+		xmlMetaItems{XMLName: xml.Name{Local: "dc:title"}, Value: info.Title},
+		xmlMetaItems{XMLName: xml.Name{Local: "dc:language"}, Value: info.Language},
+	}
+	metaItems = append(metaItems, creators...)
+	metaItems = append(metaItems,
+		xmlMetaItems{XMLName: xml.Name{Local: "dc:description"}, Value: description},
+		xmlMetaItems{XMLName: xml.Name{Local: "dc:source"}, Value: info.Source},
+		xmlMetaItems{XMLName: xml.Name{Local: "dc:date"}, Value: modified},
+	)
+	if epub2 {
+		date := &metaItems[len(metaItems)-1]
+		date.Attributes = []xml.Attr{xml.Attr{Name: xml.Name{Local: "opf:event"}, Value: "modification"}}
+	}
 	p := xmlPackage{
 		Xmlns:            "http://www.idpf.org/2007/opf",
 		XmlnsOpf:         "http://www.idpf.org/2007/opf",
-		Version:          "3.0",
+		Version:          version,
 		UniqueIdentifier: "BookID",
 		Metadata: xmlMetaData{
-			XmlnsDC: "http://purl.org/dc/elements/1.1/",
-			Properties: []xmlMetaProperty{
-				xmlMetaProperty{Property: "dcterms:modified", Value: modified},
-			},
-			MetaItems: []xmlMetaItems{
-				xmlMetaItems{
-					XMLName:    xml.Name{Local: "dc:identifier"},
-					Value:      uuid,
-					Attributes: []xml.Attr{xml.Attr{Name: xml.Name{Local: "id"}, Value: "BookID"}},
-				},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:title"}, Value: info.Title},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:language"}, Value: info.Language},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:creator"}, Value: info.Authors},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:description"}, Value: description},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:source"}, Value: info.Source},
-				xmlMetaItems{XMLName: xml.Name{Local: "dc:date"}, Value: modified},
-			},
+			XmlnsDC:    "http://purl.org/dc/elements/1.1/",
+			Properties: properties,
+			MetaItems:  metaItems,
 		},
 		ManifestItems: manifestItems,
 		Spine: xmlSpine{
 			Toc:      "ncx",
 			Itemrefs: itemrefs,
 		},
-		GuideRefs: []xmlGuideReference{
-			xmlGuideReference{Title: "Cover page", Type: "cover", Href: "frontmatter.xhtml"},
-			xmlGuideReference{Title: "Table of content", Type: "toc", Href: "toc.xhtml"},
-		},
+		GuideRefs: guide,
 	}
 	encoded, err := xml.MarshalIndent(&p, "", " ")
 	if err != nil {
 		return err
 	}
 	encoded = bytes.ReplaceAll(encoded, []byte("></item>"), []byte("/>"))
+	encoded = bytes.ReplaceAll(encoded, []byte("></meta>"), []byte("/>"))
 	encoded = bytes.ReplaceAll(encoded, []byte("></itemref>"), []byte("/>"))
 	encoded = bytes.ReplaceAll(encoded, []byte("></reference>"), []byte("/>"))
 	_, err = dst.Write(encoded)
@@ -103,10 +159,61 @@ type xmlMetaItems struct {
 }
 
 type xmlMetaProperty struct {
-	Property string `xml:"property,attr"`
+	Id       string `xml:"id,attr,omitempty"`
+	Refines  string `xml:"refines,attr,omitempty"`
+	Property string `xml:"property,attr,omitempty"`
+	Scheme   string `xml:"scheme,attr,omitempty"`
+	Name     string `xml:"name,attr,omitempty"`
+	Content  string `xml:"content,attr,omitempty"`
 	Value    string `xml:",chardata"`
 }
 
+// Return EPUB3 collection metadata and the equivalent Calibre metadata.
+func seriesMetadata(info EbookInfo) []xmlMetaProperty {
+	if info.Series == "" {
+		return nil
+	}
+	result := []xmlMetaProperty{
+		xmlMetaProperty{Id: "series", Property: "belongs-to-collection", Value: info.Series},
+		xmlMetaProperty{Refines: "#series", Property: "collection-type", Value: "series"},
+	}
+	if info.SeriesIndex != 0 {
+		result = append(result,
+			xmlMetaProperty{Refines: "#series", Property: "group-position", Value: formatSeriesIndex(info.SeriesIndex)})
+	}
+	result = append(result, xmlMetaProperty{Name: "calibre:series", Content: info.Series})
+	if info.SeriesIndex != 0 {
+		result = append(result, xmlMetaProperty{Name: "calibre:series_index", Content: formatSeriesIndex(info.SeriesIndex)})
+	}
+	return result
+}
+
+// Return schema.org accessibility metadata.  `cover` and `images` tell if the
+// book has a cover and images in its chapters.
+func accessibilityMetadata(cover, images bool) []xmlMetaProperty {
+	result := []xmlMetaProperty{
+		xmlMetaProperty{Property: "schema:accessMode", Value: "textual"},
+	}
+	if cover || images {
+		result = append(result, xmlMetaProperty{Property: "schema:accessMode", Value: "visual"})
+	}
+	result = append(result, xmlMetaProperty{Property: "schema:accessModeSufficient", Value: "textual"})
+	for _, feature := range []string{"structuralNavigation", "tableOfContents", "readingOrder"} {
+		result = append(result, xmlMetaProperty{Property: "schema:accessibilityFeature", Value: feature})
+	}
+	summary := "This publication has a table of contents and headings for each chapter, in reading order."
+	hazard := "none"
+	if images {
+		result = append(result, xmlMetaProperty{Property: "schema:accessibilityFeature", Value: "alternativeText"})
+		// Images come from the source and have not been checked for flashing.
+		hazard = "unknown"
+		summary += "  Images have alternative text taken from the source, where it gave any."
+	}
+	return append(result,
+		xmlMetaProperty{Property: "schema:accessibilityHazard", Value: hazard},
+		xmlMetaProperty{Property: "schema:accessibilitySummary", Value: summary})
+}
+
 type xmlItem struct {
 	Id         string     `xml:"id,attr"`
 	Href       string     `xml:"href,attr"`
@@ -129,15 +236,35 @@ type xmlGuideReference struct {
 	Href  string `xml:"href,attr"`
 }
 
-func makeNCX(info EbookInfo, uid string, dst io.Writer) error {
+func makeNavPoints(entries []*tocEntry, playOrder *int) []navPointXml {
+	var nav []navPointXml
+	for _, e := range entries {
+		fn, id := e.fileName()
+		class := "chapter"
+		if e.Chapter < 0 {
+			class = "section"
+		}
+		*playOrder++
+		nav = append(nav, navPointXml{Class: class, Id: id, PlayOrder: *playOrder, Label: e.label(),
+			Content: contentXml{Src: fn + ".xhtml"}})
+		nav[len(nav)-1].NavPoints = makeNavPoints(e.Children, playOrder)
+	}
+	return nav
+}
+
+func makeNCX(info EbookInfo, uid string, toc []*tocEntry, dst io.Writer) error {
 	nav := []navPointXml{
 		navPointXml{Class: "chapter", Id: "frontmatter", PlayOrder: 0, Label: "Front Matter", Content: contentXml{Src: "frontmatter.xhtml"}},
 	}
-	for i, ch := range info.Chapters {
-		fn := fmt.Sprintf("%04d", i)
-		id := "ch" + fn
-		label := fmt.Sprintf("%d. %s", i+1, ch.Title)
-		nav = append(nav, navPointXml{Class: "chapter", Id: id, PlayOrder: i + 1, Label: label, Content: contentXml{Src: "" + fn + ".xhtml"}})
+	playOrder := 0
+	nav = append(nav, makeNavPoints(toc, &playOrder)...)
+	depth := tocDepth(toc)
+	if depth < 1 {
+		depth = 1
+	}
+	var ncxAuthors []ncxText
+	for _, name := range contributorNames(info.AllContributors(), RoleAuthor) {
+		ncxAuthors = append(ncxAuthors, ncxText{Text: name})
 	}
 	ncx := ncxXml{
 		Xmlns:   "http://www.daisy.org/z3986/2005/ncx/",
@@ -145,12 +272,12 @@ func makeNCX(info EbookInfo, uid string, dst io.Writer) error {
 		Lang:    "en",
 		Metas: []metaNcxXml{
 			metaNcxXml{Name: "dtb:uid", Content: uid},
-			metaNcxXml{Name: "dtb:depth", Content: "1"},
+			metaNcxXml{Name: "dtb:depth", Content: fmt.Sprint(depth)},
 			metaNcxXml{Name: "dtb:totalPageCount", Content: "0"},
 			metaNcxXml{Name: "dtb:maxPageNumber", Content: "0"},
 		},
 		Title:     info.Title,
-		Author:    info.Authors,
+		Authors:   ncxAuthors,
 		NavPoints: nav,
 	}
 	encoded, err := xml.MarshalIndent(&ncx, "", " ")
@@ -170,19 +297,23 @@ type ncxXml struct {
 	Lang      string        `xml:"xml:lang,attr"`
 	Metas     []metaNcxXml  `xml:"head>meta"`
 	Title     string        `xml:"docTitle>text"`
-	Author    string        `xml:"docAuthor>text"`
+	Authors   []ncxText     `xml:"docAuthor"`
 	NavPoints []navPointXml `xml:"navMap>navPoint"`
 }
+type ncxText struct {
+	Text string `xml:"text"`
+}
 type metaNcxXml struct {
 	Name    string `xml:"name,attr"`
 	Content string `xml:"content,attr"`
 }
 type navPointXml struct {
-	Class     string     `xml:"class,attr"`
-	Id        string     `xml:"id,attr"`
-	PlayOrder int        `xml:"playOrder,attr"`
-	Label     string     `xml:"navLabel>text"`
-	Content   contentXml `xml:"content"`
+	Class     string        `xml:"class,attr"`
+	Id        string        `xml:"id,attr"`
+	PlayOrder int           `xml:"playOrder,attr"`
+	Label     string        `xml:"navLabel>text"`
+	Content   contentXml    `xml:"content"`
+	NavPoints []navPointXml `xml:"navPoint"`
 }
 type contentXml struct {
 	Src string `xml:"src,attr"`
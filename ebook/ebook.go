@@ -3,14 +3,15 @@
 package ebook
 
 import (
+	"crypto/sha1"
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,11 +25,14 @@ type Chapter struct {
 	Url      string
 	Content  *Node
 	Modified time.Time
+	// Titles of the enclosing sections (parts, volumes, arcs), outermost first.
+	// Consecutive chapters with the same section titles are grouped together.
+	Sections []string
 }
 
 // Ebook content and metadata.
 type EbookInfo struct {
-	Authors  string
+	Authors  string // Superseded by Contributors, if that is not empty.
 	Comments string
 	Title    string
 	Source   string
@@ -36,9 +40,25 @@ type EbookInfo struct {
 	Chapters []Chapter
 	Modified time.Time
 	Cover    []byte
+	// Authors, translators, editors, and illustrators.
+	Contributors []Contributor
+	// The series that the book belongs to, and its position in the series
+	// (e.g. 3 for the third book; zero if unknown).
+	Series      string
+	SeriesIndex float64
+	// Unique identifier of the book.  If empty, one is derived from Source.
+	Identifier string
+	// If not empty, replaces DefaultStyle as the book's stylesheet.  See
+	// also ThemeEInk, ThemeCompact, and ThemeTraditional.
+	Style string
+	// Appended to the book's stylesheet.
+	ExtraStyle string
+	// Fonts embedded in the book, declared with @font-face rules.
+	Fonts []Font
 }
 
-const bookStyle = `
+// The default stylesheet of a book.
+const DefaultStyle = `
 div p{text-indent:2em;margin-top:0;margin-bottom:0}
 div p:first-child{text-indent:0;}
 table, th, td { border:2px solid #808080; padding:3px; }
@@ -50,6 +70,7 @@ ol.flat li::before {content:"[";}
 div.mid {margin: 0 auto;}
 div.mid p {text-indent:0;}
 div.center {margin-left:auto;margin-right:auto;}
+h1.section {text-align:center;margin-top:30%;}
 `
 
 const conatainer_xml = xml.Header + `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
@@ -74,14 +95,14 @@ func meta(name, content string) *Node {
 	return dom.Element("meta", dom.Attr{"name": name, "content": content})
 }
 
-func head(title, style, comment string) *Node {
+func head(title, comment string) *Node {
 	return dom.Elem("head",
 		dom.Element("meta", dom.Attr{
 			"http-equiv": "Content-Type", "content": "text/html; charset=utf-8"}),
 		dom.Comment(comment),
 		meta("viewport", "width=device-width, initial-scale=1.0"),
 		dom.Elem("title", dom.Text(title)),
-		dom.Elem("style", dom.Text(style)),
+		dom.Element("link", dom.Attr{"rel": "stylesheet", "type": "text/css", "href": "style.css"}),
 	)
 }
 
@@ -107,11 +128,12 @@ func (info *EbookInfo) Cleanup() {
 // Write the ebook as a single HTML file.
 func (info EbookInfo) WriteHtml(dst io.Writer) error {
 	body := dom.Elem("body", nl())
+	info.Cover = info.coverOrGenerated()
 	if len(info.Cover) > 0 {
 		dom.Append(body,
 			dom.Element("div", dom.Attr{"style": "text-align:center"},
 				nl(),
-				imgElem(dataUrl(info.Cover), "[COVER]"),
+				imgElem(dataUrl(info.Cover), coverAltText(info)),
 				nl()),
 			nl(),
 			dom.Elem("hr"),
@@ -121,7 +143,9 @@ func (info EbookInfo) WriteHtml(dst io.Writer) error {
 	dom.Append(body,
 		dom.Elem("div", nl(),
 			dom.Elem("h1", dom.Text(info.Title)), nl(),
-			dom.Elem("div", dom.Text("Author: "+info.Authors)), nl(),
+			dom.Elem("div", dom.Text("Author: "+info.AuthorNames())), nl(),
+			contributorsElem(info), nl(),
+			seriesElem(info), nl(),
 			dom.Elem("div",
 				dom.Text("Source: "),
 				dom.Element("a", dom.Attr{"href": info.Source}, dom.Text(info.Source)),
@@ -129,7 +153,30 @@ func (info EbookInfo) WriteHtml(dst io.Writer) error {
 		), nl(),
 		dom.Elem("hr"), nl(),
 	)
-	for i, chapter := range info.Chapters {
+	toc := makeToc(info.Chapters)
+	if tocDepth(toc) > 1 {
+		dom.Append(body,
+			dom.Element("div", dom.Attr{"class": "toc"}, nl(),
+				dom.Elem("h2", dom.Text("Contents")), nl(),
+				tocList(toc, func(e *tocEntry) string {
+					if e.Chapter < 0 {
+						return fmt.Sprintf("#sec%03d", e.Section)
+					}
+					return fmt.Sprintf("#ch%03d", e.Chapter)
+				}), nl(),
+			), nl(),
+			dom.Elem("hr"), nl(),
+		)
+	}
+	walkToc(toc, func(e *tocEntry) {
+		if e.Chapter < 0 {
+			attr := dom.Attr{"class": "chapter", "id": fmt.Sprintf("sec%03d", e.Section)}
+			dom.Append(body,
+				dom.Elem("div", dom.Element("h1", attr, dom.Text(e.Title)), nl(), dom.Elem("hr"), nl()),
+				dom.Text("\n\n"))
+			return
+		}
+		i, chapter := e.Chapter, info.Chapters[e.Chapter]
 		attr := dom.Attr{"class": "chapter", "id": fmt.Sprintf("ch%03d", i)}
 		div := dom.Elem("div",
 			dom.Element("h2", attr, dom.Text(chapter.Title)), nl())
@@ -144,7 +191,7 @@ func (info EbookInfo) WriteHtml(dst io.Writer) error {
 			dom.Append(div, dom.Elem("div", link(info.Source, info.Source)), nl(), dom.Elem("hr"), nl())
 		}
 		dom.Append(body, div, dom.Text("\n\n"))
-	}
+	})
 	description := info.Source
 	if len(info.Comments) > 0 {
 		description = description + "\n\n" + info.Comments
@@ -153,21 +200,25 @@ func (info EbookInfo) WriteHtml(dst io.Writer) error {
 	if !info.Modified.IsZero() {
 		dcDateModified = meta("DC.date.modified", info.Modified.Format("2006-01-02"))
 	}
-	htmlNode := dom.Element("html", dom.Attr{"lang": info.Language}, nl(),
-		dom.Elem("head", nl(),
-			dom.Element("meta", dom.Attr{"charset": "utf-8"}), nl(),
-			meta("viewport", "width=device-width, initial-scale=1.0"), nl(),
-			dom.Elem("title", dom.Text(info.Title)), nl(),
-			meta("DC.title", info.Title), nl(),
-			meta("DC.creator.aut", info.Authors), nl(),
-			meta("DC.description", description), nl(),
-			meta("DC.source", info.Source), nl(),
-			meta("DC.language", info.Language), nl(),
-			dcDateModified, nl(),
-			dom.Elem("style", dom.Text(bookStyle)), nl(),
-		),
-		nl(), body, nl(),
+	headNode := dom.Elem("head", nl(),
+		dom.Element("meta", dom.Attr{"charset": "utf-8"}), nl(),
+		meta("viewport", "width=device-width, initial-scale=1.0"), nl(),
+		dom.Elem("title", dom.Text(info.Title)), nl(),
+		meta("DC.title", info.Title), nl(),
 	)
+	for _, c := range info.AllContributors() {
+		dom.Append(headNode, meta("DC.creator."+c.role(), c.Name), nl())
+	}
+	dom.Append(headNode,
+		meta("DC.description", description), nl(),
+		meta("DC.source", info.Source), nl(),
+		meta("DC.language", info.Language), nl(),
+		dcDateModified, nl(),
+		dom.Elem("style", dom.Text(fontFaceRules(makeEpubFonts(info.Fonts), func(f epubFont) string {
+			return dataUrl(f.Data)
+		})+info.Stylesheet())), nl(),
+	)
+	htmlNode := dom.Element("html", dom.Attr{"lang": info.Language}, nl(), headNode, nl(), body, nl())
 	err := dom.RenderHTML(htmlNode, dst)
 	for _, chapter := range info.Chapters {
 		dom.Remove(chapter.Content)
@@ -177,11 +228,17 @@ func (info EbookInfo) WriteHtml(dst io.Writer) error {
 
 // Print information about the book.
 func (info EbookInfo) Print(dst io.Writer) {
-	fmt.Fprintf(dst, "Authors:  %q\n", info.Authors)
+	fmt.Fprintf(dst, "Authors:  %q\n", info.AuthorNames())
+	for _, c := range info.Contributors {
+		fmt.Fprintf(dst, "* %-12s %q (%q)\n", c.RoleName()+":", c.Name, c.FileAs)
+	}
 	fmt.Fprintf(dst, "Comments: %q\n", info.Comments)
 	fmt.Fprintf(dst, "Title:    %q\n", info.Title)
 	fmt.Fprintf(dst, "Source:   %q\n", info.Source)
 	fmt.Fprintf(dst, "Language: %q\n", info.Language)
+	if info.Series != "" {
+		fmt.Fprintf(dst, "Series:   %q #%s\n", info.Series, formatSeriesIndex(info.SeriesIndex))
+	}
 	fmt.Fprintf(dst, "Cover:    %d bytes\n", len(info.Cover))
 	fmt.Fprintf(dst, "Modified: %s\n", info.Modified.Format(time.RFC3339))
 	fmt.Fprintf(dst, "Chapters: %d\n", len(info.Chapters))
@@ -190,18 +247,109 @@ func (info EbookInfo) Print(dst io.Writer) {
 		fmt.Fprintf(dst, "  Url:   %q\n", ch.Url)
 		fmt.Fprintf(dst, "  Text:  %d bytes\n", dom.TextBytes(ch.Content))
 		fmt.Fprintf(dst, "  Mod:   %s\n", ch.Modified.Format(time.RFC3339))
+		if len(ch.Sections) > 0 {
+			fmt.Fprintf(dst, "  Sections: %q\n", ch.Sections)
+		}
 	}
 }
 
-// Write the ebook as an Epub.
+// Settings for `EbookInfo.WriteEpub`.  The zero value gives the defaults.
+type EpubOptions struct {
+	// Used to download the images referenced by chapters.  If nil,
+	// `DownloadImage` is used.
+	FetchImage ImageFetcher
+	// Chapters larger than this many bytes of XHTML are split into several
+	// files.  Zero means DefaultMaxChapterSize; negative means never split.
+	MaxChapterSize int
+	// If true, footnotes found in a chapter are collected at its end, rather
+	// than placed after the paragraph that refers to them.
+	Endnotes bool
+	// If true, notes are left as they are in the chapter content, rather than
+	// converted into EPUB footnotes or endnotes.
+	KeepNotes bool
+	// How the cover is sized and encoded.
+	Cover CoverOptions
+	// If true, write an EPUB 2.0.1 package, for old readers: the NCX is the
+	// only table of contents, and content documents are XHTML 1.1.
+	Epub2 bool
+}
+
+// Return MaxChapterSize, or its default.
+func (options EpubOptions) maxChapterSize() int {
+	if options.MaxChapterSize == 0 {
+		return DefaultMaxChapterSize
+	}
+	return options.MaxChapterSize
+}
+
+// The name of the package metadata that records `EpubOptions.chapterLayout`.
+const chapterLayoutMeta = "facility:chapter-layout"
+
+// Return a description of the options that shape chapter files, such as
+// "max-size=300000 notes=footnotes".
+func (options EpubOptions) chapterLayout() string {
+	maxSize := options.maxChapterSize()
+	if maxSize < 0 {
+		maxSize = -1
+	}
+	notes := "footnotes"
+	if options.KeepNotes {
+		notes = "keep"
+	} else if options.Endnotes {
+		notes = "endnotes"
+	}
+	return fmt.Sprintf("max-size=%d notes=%s", maxSize, notes)
+}
+
+// Write the ebook as an Epub, using the default EpubOptions.
 func (info EbookInfo) Write(dst io.Writer) error {
+	return info.WriteEpub(dst, EpubOptions{})
+}
+
+// Write the ebook as an Epub.  Images referenced by the chapters are stored in
+// the Epub.
+func (info EbookInfo) WriteEpub(dst io.Writer, options EpubOptions) error {
+	return info.writeEpub(dst, options, nil)
+}
+
+// Write the ebook as an Epub.  The chapters in `reuse` are copied from another
+// Epub rather than rendered.
+func (info EbookInfo) writeEpub(dst io.Writer, options EpubOptions, reuse map[int]reusedChapter) error {
 	var (
-		uid   string = randomUUID()
+		uid   string = info.BookIdentifier()
 		cover []byte
 	)
+	toc := makeToc(info.Chapters)
+	fonts := makeEpubFonts(info.Fonts)
+	images := makeImageCollector(options.FetchImage)
+	maxChapterSize := options.maxChapterSize()
+	chapters := make([]Chapter, len(info.Chapters))
+	parts := make([][]*Node, len(info.Chapters))
+	partCounts := make([]int, len(info.Chapters))
+	for i, chapter := range info.Chapters {
+		if reused, ok := reuse[i]; ok {
+			for _, image := range reused.Images {
+				images.add(image)
+			}
+			partCounts[i] = len(reused.Files)
+		} else {
+			chapter.Content = images.embed(dom.Clone(chapter.Content), chapter.Url)
+			if !options.KeepNotes {
+				chapter.Content = convertNotes(chapter.Content, chapter.Url, options.Endnotes)
+			}
+			parts[i] = []*Node{chapter.Content}
+			if maxChapterSize > 0 {
+				parts[i] = splitContent(chapter.Content, maxChapterSize)
+				fixPartLinks(parts[i], i)
+			}
+			partCounts[i] = len(parts[i])
+		}
+		chapters[i] = chapter
+	}
+	info.Cover = info.coverOrGenerated()
 	if len(info.Cover) > 0 {
 		var err error
-		cover, err = saveJpegWithScale(info.Cover, 400, 600)
+		cover, err = processCover(info.Cover, options.Cover)
 		if err != nil {
 			log.Printf("Cover error: %v", err)
 			cover = nil
@@ -221,36 +369,93 @@ func (info EbookInfo) Write(dst io.Writer) error {
 	if w := zw.CreateDeflate("META-INF/container.xml", modTime); w != nil {
 		_, zw.Error = w.Write([]byte(conatainer_xml))
 	}
+	for _, font := range fonts {
+		if font.Obfuscate {
+			if w := zw.CreateDeflate("META-INF/encryption.xml", modTime); w != nil {
+				zw.Error = writeEncryption(fonts, w)
+			}
+			break
+		}
+	}
 	if w := zw.CreateDeflate("book/"+"toc.ncx", modTime); w != nil {
-		zw.Error = makeNCX(info, uid, w)
+		zw.Error = makeNCX(info, uid, toc, w)
 	}
 	if w := zw.CreateDeflate("book/"+"content.opf", modTime); w != nil {
-		zw.Error = makePackage(info, uid, w, len(cover) > 0)
+		zw.Error = makePackage(info, uid, toc, w, len(cover) > 0, images.images, fonts, partCounts, options)
+	}
+	if w := zw.CreateDeflate("book/"+"style.css", modTime); w != nil {
+		rules := fontFaceRules(fonts, func(f epubFont) string { return f.Href })
+		_, zw.Error = io.WriteString(w, rules+info.Stylesheet())
 	}
 	if w := zw.CreateDeflate("book/"+"frontmatter.xhtml", modTime); w != nil {
-		zw.Error = writeFrontmatter(info, w, len(cover) > 0)
+		zw.Error = writeFrontmatter(info, w, len(cover) > 0, options.Epub2)
 	}
-	if w := zw.CreateDeflate("book/"+"toc.xhtml", modTime); w != nil {
-		zw.Error = writeToc(info, w)
+	if !options.Epub2 {
+		if w := zw.CreateDeflate("book/"+"toc.xhtml", modTime); w != nil {
+			zw.Error = writeToc(info, toc, len(cover) > 0, w)
+		}
 	}
 	if len(cover) > 0 {
 		if w := zw.CreateStore("book/"+"cover.jpg", modTime); w != nil {
 			_, zw.Error = w.Write(cover)
 		}
 	}
-	for i, chapter := range info.Chapters {
-		if w := zw.CreateDeflate(fmt.Sprintf("book/"+"%04d.xhtml", i), chapter.Modified); w != nil {
-			var churl string
-			if i+1 == len(info.Chapters) {
-				churl = chapter.Url
+	for _, font := range fonts {
+		create, data := zw.CreateDeflate, font.Data
+		if font.MediaType == "font/woff" || font.MediaType == "font/woff2" {
+			create = zw.CreateStore // Already compressed.
+		}
+		if font.Obfuscate {
+			data = obfuscateFont(data, uid)
+		}
+		if w := create("book/"+font.Href, modTime); w != nil {
+			_, zw.Error = w.Write(data)
+		}
+	}
+	for _, image := range images.images {
+		if image.File != nil {
+			zw.Copy(image.File)
+			continue
+		}
+		create := zw.CreateStore
+		if image.MediaType == "image/svg+xml" {
+			create = zw.CreateDeflate
+		}
+		if w := create("book/"+image.Href, modTime); w != nil {
+			_, zw.Error = w.Write(image.Data)
+		}
+	}
+	walkToc(toc, func(e *tocEntry) {
+		if e.Chapter < 0 {
+			name, _ := e.fileName()
+			if w := zw.CreateDeflate("book/"+name+".xhtml", modTime); w != nil {
+				zw.Error = writeSection(e.Title, info.Language, options.Epub2, w)
+			}
+		}
+	})
+	for i, chapter := range chapters {
+		if reused, ok := reuse[i]; ok {
+			for _, f := range reused.Files {
+				zw.Copy(f)
+			}
+			continue
+		}
+		for j, part := range parts[i] {
+			if w := zw.CreateDeflate("book/"+chapterPartName(i, j)+".xhtml", chapter.Modified); w != nil {
+				var churl string
+				last := j+1 == len(parts[i])
+				if last && i+1 == len(chapters) {
+					churl = chapter.Url
+				}
+				chapter.Content = part
+				zw.Error = writeChapter(chapter, j, last, churl, info.Language, options.Epub2, w)
 			}
-			zw.Error = writeChapter(chapter, churl, info.Language, w)
 		}
 	}
 	return zw.Error
 }
 
-func writeFrontmatter(info EbookInfo, dst io.Writer, cover bool) error {
+func writeFrontmatter(info EbookInfo, dst io.Writer, cover, epub2 bool) error {
 	description := dom.Elem("div")
 	for _, p := range strings.Split(info.Comments, "\n\n") {
 		pnode := dom.Elem("p")
@@ -264,48 +469,80 @@ func writeFrontmatter(info EbookInfo, dst io.Writer, cover bool) error {
 	}
 	var img *dom.Node
 	if cover {
-		img = dom.Element("img", dom.Attr{"src": "cover.jpg", "alt": "[COVER]"})
+		img = dom.Element("img", dom.Attr{"src": "cover.jpg", "alt": coverAltText(info)})
 	}
 	htmlNode := dom.Element("html", dom.Attr{"xmlns": "http://www.w3.org/1999/xhtml", "xml:lang": info.Language},
-		head(info.Title, bookStyle, ""),
+		head(info.Title, ""),
 		dom.Elem("body",
 			dom.Elem("h1", dom.Text(info.Title)),
 			img,
-			dom.Elem("div", dom.Text(info.Authors)),
+			dom.Elem("div", dom.Text(info.AuthorNames())),
+			contributorsElem(info),
+			seriesElem(info),
 			dom.Elem("div", dom.Text(info.Source)),
 			dom.Elem("div", dom.Elem("em", dom.Text(info.Modified.Format("2006-01-02")))),
 			description,
 		),
 	)
-	return dom.RenderXHTMLDoc(htmlNode, dst)
+	return renderContentDoc(htmlNode, epub2, dst)
 }
 
-func writeChapter(chapter Chapter, url, lang string, dst io.Writer) error {
+// Write part `part` of a chapter, whose content is `chapter.Content`.  Only
+// the first part has a heading, and only the last part has a closing rule.
+// If `epub2` is set, the chapter is written as XHTML 1.1.
+func writeChapter(chapter Chapter, part int, last bool, url, lang string, epub2 bool, dst io.Writer) error {
 	body := dom.Elem("body")
-	if chapter.Url != "" {
-		dom.Append(body, dom.Comment(fmt.Sprintf("\n%s\n", chapter.Url)))
+	if part == 0 {
+		if chapter.Url != "" {
+			dom.Append(body, dom.Comment(fmt.Sprintf("\n%s\n", chapter.Url)))
+		}
+		dom.Append(body, dom.Element("h2", dom.Attr{"class": "chapter"}, dom.Text(chapter.Title)))
+		if !chapter.Modified.IsZero() {
+			dom.Append(body, dom.Elem("p", dom.Elem("em", dom.Text(chapter.Modified.Format("2006-01-02")))))
+		}
+		dom.Append(body, dom.Elem("hr"))
+	} else {
+		dom.AddAttribute(body, "class", "continued")
 	}
-	dom.Append(body, dom.Element("h2", dom.Attr{"class": "chapter"}, dom.Text(chapter.Title)))
-	if !chapter.Modified.IsZero() {
-		dom.Append(body, dom.Elem("p", dom.Elem("em", dom.Text(chapter.Modified.Format("2006-01-02")))))
+	dom.AddAttribute(body, "epub:type", "chapter")
+	dom.Append(body, chapter.Content)
+	if last {
+		dom.Append(body, dom.Elem("hr"))
 	}
-	dom.Append(body, dom.Elem("hr"), chapter.Content, dom.Elem("hr"))
 	if url != "" {
 		dom.Append(body, dom.Elem("div", link(url, url)), dom.Elem("hr"))
 	}
 	htmlNode := dom.Element("html",
-		dom.Attr{"xmlns": "http://www.w3.org/1999/xhtml", "xml:lang": lang},
-		head(chapter.Title, bookStyle, ""),
+		dom.Attr{
+			"xmlns":      "http://www.w3.org/1999/xhtml",
+			"xml:lang":   lang,
+			"xmlns:epub": "http://www.idpf.org/2007/ops",
+		},
+		head(chapter.Title, ""),
 		body,
 	)
-	return dom.RenderXHTMLDoc(htmlNode, dst)
+	return renderContentDoc(htmlNode, epub2, dst)
 }
 
-func writeToc(info EbookInfo, dst io.Writer) error {
-	links := dom.Element("ol", dom.Attr{"class": "flat"})
-	for i, ch := range info.Chapters {
-		label := fmt.Sprintf("%d. %s", i+1, ch.Title)
-		dom.Append(links, dom.Elem("li", link(fmt.Sprintf("%04d.xhtml", i), label)))
+// Write the navigation document, with the table of contents and the
+// landmarks of the book.
+func writeToc(info EbookInfo, toc []*tocEntry, cover bool, dst io.Writer) error {
+	links := tocList(toc, func(e *tocEntry) string {
+		name, _ := e.fileName()
+		return name + ".xhtml"
+	})
+	dom.AddAttribute(links, "class", "flat")
+	landmark := func(kind, href, title string) *Node {
+		return dom.Elem("li", dom.Element("a", dom.Attr{"epub:type": kind, "href": href}, dom.Text(title)))
+	}
+	landmarks := dom.Elem("ol")
+	if cover {
+		dom.Append(landmarks, landmark("cover", "frontmatter.xhtml", "Cover"))
+	}
+	dom.Append(landmarks, landmark("toc", "toc.xhtml", "Contents"))
+	if len(toc) > 0 {
+		name, _ := toc[0].fileName()
+		dom.Append(landmarks, landmark("bodymatter", name+".xhtml", "Start of Content"))
 	}
 	htmlNode := dom.Element("html",
 		dom.Attr{
@@ -313,17 +550,60 @@ func writeToc(info EbookInfo, dst io.Writer) error {
 			"xml:lang":   info.Language,
 			"xmlns:epub": "http://www.idpf.org/2007/ops",
 		},
-		head(info.Title, bookStyle, ""),
+		head(info.Title, ""),
 		dom.Elem("body",
 			dom.Element("nav",
 				dom.Attr{"epub:type": "toc", "style": "display:none;"},
 				dom.Elem("h2", dom.Text("Contents")),
 				links),
+			dom.Element("nav",
+				dom.Attr{"epub:type": "landmarks", "style": "display:none;"},
+				dom.Elem("h2", dom.Text("Landmarks")),
+				landmarks),
 		),
 	)
 	return dom.RenderXHTMLDoc(htmlNode, dst)
 }
 
+// Return the alt text of the cover image.
+func coverAltText(info EbookInfo) string {
+	if info.Title == "" {
+		return "Cover"
+	}
+	return "Cover of " + info.Title
+}
+
+func formatSeriesIndex(index float64) string {
+	return strconv.FormatFloat(index, 'f', -1, 64)
+}
+
+// Return a list of the contributors who are not authors, or nil.
+func contributorsElem(info EbookInfo) *Node {
+	lines := otherContributors(info)
+	if len(lines) == 0 {
+		return nil
+	}
+	div := dom.Elem("div")
+	for i, line := range lines {
+		if i > 0 {
+			dom.Append(div, dom.Elem("br"))
+		}
+		dom.Append(div, dom.Text(line))
+	}
+	return div
+}
+
+// Return a description of the book's place in its series, or nil.
+func seriesElem(info EbookInfo) *Node {
+	if info.Series == "" {
+		return nil
+	}
+	if info.SeriesIndex == 0 {
+		return dom.Elem("div", dom.Text("Series: "+info.Series))
+	}
+	return dom.Elem("div", dom.Text(fmt.Sprintf("Book %s of %s", formatSeriesIndex(info.SeriesIndex), info.Series)))
+}
+
 func link(url, text string) *Node {
 	if url == "" {
 		return nil
@@ -338,8 +618,31 @@ func imgElem(url, alt string) *Node {
 	return dom.Element("img", dom.Attr{"src": url, "alt": alt})
 }
 
-func randomUUID() string {
+// RFC 4122 name space for URLs.
+var urlNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// Return a name-based (version 5) UUID.
+func nameUUID(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
 	var v [16]byte
-	rand.Read(v[:])
+	copy(v[:], h.Sum(nil))
+	v[6] = (v[6] & 0x0f) | 0x50
+	v[8] = (v[8] & 0x3f) | 0x80
 	return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
 }
+
+// Return the unique identifier of the book: `Identifier` if set, otherwise a
+// UUID derived from `Source` (or from `Title` and `Authors` if there is no
+// Source), so that rebuilding a book does not change its identity.
+func (info EbookInfo) BookIdentifier() string {
+	if info.Identifier != "" {
+		return info.Identifier
+	}
+	name := info.Source
+	if name == "" {
+		name = info.Title + "\n" + info.AuthorNames()
+	}
+	return "urn:uuid:" + nameUUID(urlNamespace, name)
+}
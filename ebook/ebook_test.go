@@ -6,10 +6,12 @@ package ebook
 import (
 	"bytes"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
 )
 
 var testStrings = []string{
@@ -56,3 +58,170 @@ func TestEbook(t *testing.T) {
 	}
 
 }
+
+func TestReproducible(t *testing.T) {
+	modified := time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC)
+	var first, second bytes.Buffer
+	if err := makeTestBook(modified).Write(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeTestBook(modified).Write(&second); err != nil {
+		t.Fatal(err)
+	}
+	expect.True(t, bytes.Equal(first.Bytes(), second.Bytes()))
+
+	book := makeTestBook(modified)
+	expect.Equal(t, "urn:uuid:dd2c1780-811a-5296-81c5-178a0ef488bc", book.BookIdentifier())
+	book.Identifier = "isbn:9780000000000"
+	expect.Equal(t, "isbn:9780000000000", book.BookIdentifier())
+	data := first.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	expect.True(t, err == nil)
+	expect.Equal(t, "urn:uuid:dd2c1780-811a-5296-81c5-178a0ef488bc", result.Identifier)
+}
+
+func TestStyle(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Style = ThemeEInk
+	book.ExtraStyle = "p {color:#111;}"
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	expect.Equal(t, ThemeEInk+"\np {color:#111;}\n", entries["book/style.css"])
+	expect.True(t, strings.Contains(entries["book/0000.xhtml"], `<link href="style.css" rel="stylesheet" type="text/css"/>`))
+	expect.True(t, !strings.Contains(entries["book/0000.xhtml"], "<style>"))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	expect.True(t, err == nil)
+	expect.Equal(t, book.Stylesheet(), result.Stylesheet())
+
+	buffer.Reset()
+	book.WriteHtml(&buffer)
+	expect.True(t, strings.Contains(buffer.String(), "p {color:#111;}"))
+}
+
+func TestSeries(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Series = "The Series"
+	book.SeriesIndex = 3
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	for _, s := range []string{
+		`<meta id="series" property="belongs-to-collection">The Series</meta>`,
+		`<meta refines="#series" property="collection-type">series</meta>`,
+		`<meta refines="#series" property="group-position">3</meta>`,
+		`<meta name="calibre:series" content="The Series"/>`,
+		`<meta name="calibre:series_index" content="3"/>`,
+	} {
+		expect.True(t, strings.Contains(entries["book/content.opf"], s))
+	}
+	expect.True(t, strings.Contains(entries["book/frontmatter.xhtml"], "<div>Book 3 of The Series</div>"))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	expect.True(t, err == nil)
+	expect.Equal(t, book.Series, result.Series)
+	expect.Equal(t, book.SeriesIndex, result.SeriesIndex)
+
+	buffer.Reset()
+	book.Print(&buffer)
+	expect.True(t, strings.Contains(buffer.String(), "Series:   \"The Series\" #3\n"))
+}
+
+func TestContributors(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Authors = ""
+	book.Contributors = []Contributor{
+		{Name: "Jane Austen", Role: RoleAuthor, FileAs: "Austen, Jane"},
+		{Name: "Anne Brontë", FileAs: "Brontë, Anne"},
+		{Name: "John Doe", Role: RoleTranslator},
+	}
+	expect.Equal(t, "Jane Austen, Anne Brontë", book.AuthorNames())
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	for _, s := range []string{
+		`<dc:creator id="creator01">Jane Austen</dc:creator>`,
+		`<dc:creator id="creator02">Anne Brontë</dc:creator>`,
+		`<dc:contributor id="creator03">John Doe</dc:contributor>`,
+		`<meta refines="#creator01" property="role" scheme="marc:relators">aut</meta>`,
+		`<meta refines="#creator01" property="file-as">Austen, Jane</meta>`,
+		`<meta refines="#creator03" property="role" scheme="marc:relators">trl</meta>`,
+	} {
+		expect.True(t, strings.Contains(entries["book/content.opf"], s))
+	}
+	expect.True(t, strings.Contains(entries["book/toc.ncx"],
+		"<docAuthor>\n  <text>Jane Austen</text>\n </docAuthor>\n <docAuthor>\n  <text>Anne Brontë</text>\n </docAuthor>"))
+	expect.True(t, strings.Contains(entries["book/frontmatter.xhtml"], "<div>Translator: John Doe</div>"))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	expect.True(t, err == nil)
+	book.Contributors[1].Role = RoleAuthor
+	expect.DeepEqual(t, book.Contributors, result.Contributors)
+	expect.Equal(t, "Jane Austen, Anne Brontë", result.Authors)
+
+	buffer.Reset()
+	book.WriteHtml(&buffer)
+	expect.True(t, strings.Contains(buffer.String(), `<meta content="John Doe" name="DC.creator.trl"/>`))
+
+	// When both are set, Contributors supersedes Authors everywhere.
+	result.Contributors[0].Name = "J. Austen"
+	expect.Equal(t, "J. Austen, Anne Brontë", result.AuthorNames())
+	buffer.Reset()
+	if err := result.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	entries = readZipEntries(t, buffer.Bytes())
+	expect.True(t, strings.Contains(entries["book/content.opf"], `<dc:creator id="creator01">J. Austen</dc:creator>`))
+	expect.True(t, strings.Contains(entries["book/frontmatter.xhtml"], "<div>J. Austen, Anne Brontë</div>"))
+	buffer.Reset()
+	result.WriteText(&buffer, 0)
+	expect.True(t, strings.Contains(buffer.String(), "by J. Austen, Anne Brontë\n"))
+}
+
+func TestAccessibility(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	opf := entries["book/content.opf"]
+	for _, meta := range []string{
+		`<meta property="schema:accessMode">textual</meta>`,
+		`<meta property="schema:accessMode">visual</meta>`,
+		`<meta property="schema:accessModeSufficient">textual</meta>`,
+		`<meta property="schema:accessibilityFeature">tableOfContents</meta>`,
+		`<meta property="schema:accessibilityHazard">none</meta>`,
+		`<meta property="schema:accessibilitySummary">`,
+	} {
+		expect.True(t, strings.Contains(opf, meta))
+	}
+	nav := entries["book/toc.xhtml"]
+	expect.True(t, strings.Contains(nav, `<nav epub:type="landmarks"`))
+	expect.True(t, strings.Contains(nav, `<a epub:type="cover" href="frontmatter.xhtml">Cover</a>`))
+	expect.True(t, strings.Contains(nav, `<a epub:type="toc" href="toc.xhtml">Contents</a>`))
+	expect.True(t, strings.Contains(nav, `<a epub:type="bodymatter" href="0000.xhtml">`))
+	expect.True(t, strings.Contains(entries["book/0000.xhtml"], `<body epub:type="chapter">`))
+	expect.True(t, strings.Contains(entries["book/frontmatter.xhtml"], `alt="Cover of `+book.Title+`"`))
+
+	// ReadEpub only reads the toc navigation.
+	read, err := ReadEpub(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, len(book.Chapters), len(read.Chapters))
+}
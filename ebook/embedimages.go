@@ -0,0 +1,181 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/download"
+)
+
+// A function that fetches the content of an image.
+// @param url - the absolute URL of the image.
+// @param referer - the URL of the chapter that references the image.
+type ImageFetcher func(url, referer string) ([]byte, error)
+
+// Fetch an image with `download.GetUrl`.
+func DownloadImage(url, referer string) ([]byte, error) {
+	r, err := download.GetUrl(url, referer, false)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+var imageExtensions = map[string]string{
+	"image/gif":     ".gif",
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+}
+
+// Return the media type of image data, if it is an EPUB core media type.
+func imageMediaType(data []byte) (string, bool) {
+	mediaType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if _, ok := imageExtensions[mediaType]; ok {
+		return mediaType, true
+	}
+	if (mediaType == "text/xml" || mediaType == "text/plain") && bytes.Contains(data, []byte("<svg")) {
+		return "image/svg+xml", true
+	}
+	return "", false
+}
+
+// Decode a RFC 2397 `data:` URL.
+func decodeDataUrl(src string) ([]byte, error) {
+	header, data, found := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	if !found {
+		return nil, errors.New("bad data url")
+	}
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	decoded, err := url.PathUnescape(data)
+	return []byte(decoded), err
+}
+
+type epubImage struct {
+	Href      string
+	MediaType string
+	Data      []byte
+	File      *zip.File // If not nil, copy this instead of writing Data.
+}
+
+// Collects the images used by an Epub, one copy of each.
+type imageCollector struct {
+	fetch  ImageFetcher
+	hrefs  map[string]bool   // hrefs of stored images.
+	byUrl  map[string]string // source url to href; "" on error.
+	images []epubImage
+}
+
+func makeImageCollector(fetch ImageFetcher) imageCollector {
+	if fetch == nil {
+		fetch = DownloadImage
+	}
+	return imageCollector{fetch: fetch, hrefs: map[string]bool{}, byUrl: map[string]string{}}
+}
+
+// Store an image, unless an image with the same href is already stored.
+func (c *imageCollector) add(image epubImage) {
+	if !c.hrefs[image.Href] {
+		c.hrefs[image.Href] = true
+		c.images = append(c.images, image)
+	}
+}
+
+func (c *imageCollector) get(src, referer string) (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case strings.HasPrefix(src, "data:"):
+		data, err = decodeDataUrl(src)
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+		data, err = c.fetch(src, referer)
+	default:
+		err = errors.New("unsupported url")
+	}
+	if err != nil {
+		return "", err
+	}
+	mediaType, ok := imageMediaType(data)
+	if !ok {
+		return "", errors.New("not an image")
+	}
+	// Images are named by content, so identical images are stored once.
+	hash := sha256.Sum256(data)
+	href := "images/" + hex.EncodeToString(hash[:8]) + imageExtensions[mediaType]
+	c.add(epubImage{Href: href, MediaType: mediaType, Data: data})
+	return href, nil
+}
+
+// Store every image referenced by `content`, and rewrite the `src` attributes
+// to point at the stored copy.  Images that can not be fetched are replaced
+// by their alt text.
+func (c *imageCollector) embed(content *Node, referer string) *Node {
+	for _, img := range dom.FindNodesByTagAndAttrib(content, "img", "", "") {
+		src := getNodeAttribute(img, "src")
+		if src == nil {
+			continue
+		}
+		href, seen := c.byUrl[src.Val]
+		if !seen {
+			var err error
+			if href, err = c.get(src.Val, referer); err != nil {
+				if !strings.HasPrefix(src.Val, "data:null;") {
+					log.Printf("Image error: %.100s: %v", src.Val, err)
+				}
+			}
+			c.byUrl[src.Val] = href
+		}
+		if href != "" {
+			src.Val = href
+			setAltText(img)
+		} else if img == content {
+			return dom.Text(dom.GetAttribute(img, "alt"))
+		} else {
+			if alt := dom.GetAttribute(img, "alt"); alt != "" {
+				img.Parent.InsertBefore(dom.Text(alt), img)
+			}
+			dom.Remove(img)
+		}
+	}
+	return content
+}
+
+// Give an image that has no alt text some, from its title, its label, or the
+// caption of its figure.  An image with none of these is marked as decorative
+// with an empty alt text.
+func setAltText(img *Node) {
+	if alt := getNodeAttribute(img, "alt"); alt != nil && strings.TrimSpace(alt.Val) != "" {
+		return
+	}
+	text := dom.GetAttribute(img, "title")
+	if text == "" {
+		text = dom.GetAttribute(img, "aria-label")
+	}
+	for node := img.Parent; text == "" && node != nil; node = node.Parent {
+		if isElement(node, "figure") {
+			if caption := dom.FindNodeByTag(node, "figcaption"); caption != nil {
+				text = dom.ExtractText(caption)
+			}
+			break
+		}
+	}
+	text = strings.TrimSpace(whitespaceRegexp.ReplaceAllString(text, " "))
+	setAttribute(img, "alt", text)
+}
@@ -0,0 +1,116 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func readZipEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[f.Name] = string(b)
+	}
+	return result
+}
+
+func TestEmbedImages(t *testing.T) {
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 4, 4)))
+	fetched := 0
+	fetch := func(url, referer string) ([]byte, error) {
+		fetched++
+		expect.Equal(t, "https://example.com/ch1", referer)
+		if strings.HasSuffix(url, ".png") {
+			return pngData.Bytes(), nil
+		}
+		return nil, errors.New("404")
+	}
+	content := dom.Elem("div",
+		dom.Elem("p", dom.Element("img", dom.Attr{"src": "https://example.com/a.png", "alt": "A"})),
+		dom.Elem("p", dom.Element("img", dom.Attr{"src": "https://example.com/a.png", "alt": "A"})),
+		dom.Elem("p", dom.Element("img", dom.Attr{"src": "https://example.com/b.png", "alt": "B"})),
+		dom.Elem("p", dom.Element("img", dom.Attr{"src": "https://example.com/missing", "alt": "MISSING"})),
+	)
+	book := EbookInfo{
+		Title:    "Images",
+		Language: "en",
+		Chapters: []Chapter{{Title: "One", Url: "https://example.com/ch1", Content: content}},
+	}
+	var buffer bytes.Buffer
+	if err := book.WriteEpub(&buffer, EpubOptions{FetchImage: fetch}); err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, 3, fetched)
+	expectValidEpub(t, buffer.Bytes())
+	var images []string
+	entries := readZipEntries(t, buffer.Bytes())
+	for name := range entries {
+		if strings.HasPrefix(name, "book/images/") {
+			images = append(images, strings.TrimPrefix(name, "book/"))
+		}
+	}
+	if !expect.Equal(t, 1, len(images)) {
+		return
+	}
+	expect.True(t, strings.Contains(entries["book/content.opf"], `href="`+images[0]+`" media-type="image/png"`))
+	chapter := entries["book/0000.xhtml"]
+	expect.Equal(t, 3, strings.Count(chapter, `src="`+images[0]+`"`))
+	expect.True(t, strings.Contains(chapter, "<p>MISSING</p>"))
+	expect.Equal(t, "https://example.com/a.png", dom.GetAttribute(dom.FindNodeByTag(content, "img"), "src"))
+
+	// Images survive a round trip through ReadEpub.
+	data := buffer.Bytes()
+	book, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Reset()
+	if err := book.WriteEpub(&buffer, EpubOptions{FetchImage: fetch}); err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, 3, fetched)
+	expect.Equal(t, chapter, readZipEntries(t, buffer.Bytes())["book/0000.xhtml"])
+}
+
+func TestSetAltText(t *testing.T) {
+	for _, tc := range []struct{ source, alt string }{
+		{`<img src="a.png" alt="Given"/>`, "Given"},
+		{`<img src="a.png" title="A title"/>`, "A title"},
+		{`<img src="a.png" aria-label="A label" alt=" "/>`, "A label"},
+		{`<figure><img src="a.png"/><figcaption>The
+			caption</figcaption></figure>`, "The caption"},
+		{`<img src="a.png"/>`, ""},
+	} {
+		content := parseContent(t, "<div>"+tc.source+"</div>")
+		img := dom.FindNodeByTag(content, "img")
+		setAltText(img)
+		expect.Equal(t, tc.alt, dom.GetAttribute(img, "alt"))
+		expect.True(t, getNodeAttribute(img, "alt") != nil)
+	}
+}
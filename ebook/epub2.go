@@ -0,0 +1,109 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// HTML5 elements that XHTML 1.1 lacks, and the elements that replace them.
+var xhtml11Replacements = map[string]string{
+	"article": "div", "aside": "div", "details": "div", "dialog": "div", "figcaption": "div",
+	"figure": "div", "footer": "div", "header": "div", "hgroup": "div", "main": "div",
+	"nav": "div", "section": "div", "summary": "div",
+	"bdi": "span", "data": "span", "mark": "span", "meter": "span", "output": "span",
+	"picture": "span", "progress": "span", "s": "del", "strike": "del", "time": "span",
+	"u": "span",
+}
+
+// HTML5 elements that XHTML 1.1 lacks and that are removed with their content.
+var xhtml11Removals = map[string]bool{
+	"audio": true, "canvas": true, "datalist": true, "source": true,
+	"template": true, "track": true, "video": true, "wbr": true,
+}
+
+// Change the document into XHTML 1.1: HTML5 elements are replaced or removed,
+// and `epub:type` attributes become classes, except on the body.
+func downgradeToXhtml11(node *Node) {
+	var next *Node
+	for child := node.FirstChild; child != nil; child = next {
+		next = child.NextSibling
+		if child.Type == dom.ElementNode && xhtml11Removals[child.Data] {
+			node.RemoveChild(child)
+			continue
+		}
+		downgradeToXhtml11(child)
+	}
+	if node.Type != dom.ElementNode {
+		return
+	}
+	class := dom.GetAttribute(node, "class")
+	if replacement, ok := xhtml11Replacements[node.Data]; ok {
+		class = strings.TrimSpace(class + " " + node.Data)
+		node.Data, node.DataAtom = replacement, 0
+	}
+	attrs := node.Attr[:0]
+	for _, attr := range node.Attr {
+		switch {
+		case attr.Namespace == "epub" && attr.Key == "type" && node.Data != "body":
+			// A body class of "chapter" would confuse `ConvertToEbook`.
+			class = strings.TrimSpace(class + " " + attr.Val)
+		case attr.Namespace == "xmlns" && attr.Key == "epub", attr.Namespace == "epub":
+		case attr.Namespace == "" && attr.Key == "class":
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	node.Attr = attrs
+	if class != "" {
+		dom.AddAttribute(node, "class", class)
+	}
+}
+
+// Render a content document, as XHTML 1.1 if `epub2` is set.
+func renderContentDoc(htmlNode *Node, epub2 bool, dst io.Writer) error {
+	if !epub2 {
+		return dom.RenderXHTMLDoc(htmlNode, dst)
+	}
+	downgradeToXhtml11(htmlNode)
+	doc := &Node{Type: dom.DocumentNode}
+	dom.Append(doc, dom.XHTML11Doctype(), dom.Text("\n"), htmlNode)
+	return dom.RenderXHTMLDoc(doc, dst)
+}
+
+// Return OPF 2.0 creator and contributor elements.
+func contributorMetadata2(info EbookInfo) []xmlMetaItems {
+	var items []xmlMetaItems
+	for _, c := range info.AllContributors() {
+		tag := "dc:contributor"
+		if c.role() == RoleAuthor {
+			tag = "dc:creator"
+		}
+		attributes := []xml.Attr{xml.Attr{Name: xml.Name{Local: "opf:role"}, Value: c.role()}}
+		if c.FileAs != "" {
+			attributes = append(attributes, xml.Attr{Name: xml.Name{Local: "opf:file-as"}, Value: c.FileAs})
+		}
+		items = append(items, xmlMetaItems{XMLName: xml.Name{Local: tag}, Value: c.Name, Attributes: attributes})
+	}
+	return items
+}
+
+// Return OPF 2.0 `meta` elements equivalent to EPUB3 metadata properties.
+// Properties that refine other metadata are dropped.
+func epub2Properties(properties []xmlMetaProperty) []xmlMetaProperty {
+	var result []xmlMetaProperty
+	for _, p := range properties {
+		switch {
+		case p.Name != "":
+			result = append(result, p)
+		case p.Refines == "" && strings.HasPrefix(p.Property, "schema:"):
+			result = append(result, xmlMetaProperty{Name: p.Property, Content: p.Value})
+		}
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestEpub2(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Authors = ""
+	book.Contributors = []Contributor{
+		{Name: "The Author", Role: RoleAuthor},
+		{Name: "Jane Roe", FileAs: "Roe, Jane", Role: RoleTranslator},
+	}
+	book.Series = "Series"
+	book.Chapters[1].Content = dom.Elem("div",
+		dom.Elem("section", dom.Elem("p", dom.Text("Text"),
+			dom.Element("sup", nil, dom.Element("a", dom.Attr{"href": "#fn1"}, dom.Text("1"))))),
+		dom.Element("ol", nil, dom.Element("li", dom.Attr{"id": "fn1"}, dom.Text("The note."))))
+	var buffer bytes.Buffer
+	if err := book.WriteEpub(&buffer, EpubOptions{Epub2: true}); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	opf := entries["book/content.opf"]
+	expect.True(t, strings.Contains(opf, `version="2.0"`))
+	expect.True(t, strings.Contains(opf, `<dc:creator opf:role="aut">The Author</dc:creator>`))
+	expect.True(t, strings.Contains(opf, `<dc:contributor opf:role="trl" opf:file-as="Roe, Jane">Jane Roe</dc:contributor>`))
+	expect.True(t, strings.Contains(opf, `<meta name="cover" content="cover"/>`))
+	expect.True(t, strings.Contains(opf, `<meta name="schema:accessMode" content="textual"/>`))
+	expect.True(t, strings.Contains(opf, `<meta name="calibre:series" content="Series"/>`))
+	expect.True(t, strings.Contains(opf, `opf:event="modification"`))
+	for _, absent := range []string{"properties=", "refines=", "property=", "toc.xhtml"} {
+		expect.True(t, !strings.Contains(opf, absent))
+	}
+	_, hasNav := entries["book/toc.xhtml"]
+	expect.True(t, !hasNav)
+	for _, name := range []string{"book/frontmatter.xhtml", "book/0000.xhtml", "book/0001.xhtml"} {
+		expect.True(t, strings.Contains(entries[name], `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN"`))
+		expect.True(t, !strings.Contains(entries[name], "epub:"))
+	}
+	chapter := entries["book/0001.xhtml"]
+	expect.True(t, strings.Contains(chapter, `<body><!--`))
+	expect.True(t, strings.Contains(chapter, `<div class="section"><p>`))
+	expect.True(t, strings.Contains(chapter, `<a href="#fn1" class="noteref">`))
+	expect.True(t, strings.Contains(chapter, `<div id="fn1" class="aside footnote"><p>The note.</p></div>`))
+
+	result, err := ReadEpub(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, book.Title, result.Title)
+	expect.True(t, book.Modified.Equal(result.Modified))
+	expect.Equal(t, len(book.Chapters), len(result.Chapters))
+	expect.Equal(t, "Roe, Jane", result.Contributors[1].FileAs)
+	expect.Equal(t, RoleTranslator, result.Contributors[1].Role)
+}
@@ -0,0 +1,371 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// Elements that are written on their own line in FictionBook documents.
+var fb2BlockElements = map[string]bool{
+	"FictionBook": true, "description": true, "title-info": true, "document-info": true,
+	"author": true, "translator": true, "first-name": true, "last-name": true, "nickname": true,
+	"genre": true, "book-title": true, "annotation": true, "date": true, "coverpage": true,
+	"image": true, "lang": true, "sequence": true, "program-used": true, "src-url": true,
+	"id": true, "version": true, "body": true, "section": true, "title": true, "p": true,
+	"subtitle": true, "cite": true, "empty-line": true, "table": true, "tr": true, "binary": true,
+}
+
+// Write the ebook as a FictionBook 2 document.  Sections become nested
+// `section` elements, and the cover is stored as a `binary` element.
+func (info EbookInfo) WriteFB2(dst io.Writer) error {
+	var cover []byte
+	if len(info.Cover) > 0 {
+		var err error
+		cover, err = processCover(info.Cover, CoverOptions{})
+		if err != nil {
+			log.Printf("Cover error: %v", err)
+			cover = nil
+		}
+	}
+
+	titleInfo := dom.Elem("title-info", dom.Elem("genre", dom.Text("prose_contemporary")))
+	var authors, translators []*Node
+	for _, c := range info.AllContributors() {
+		switch c.role() {
+		case RoleAuthor:
+			authors = append(authors, fb2Person("author", c))
+		case RoleTranslator:
+			translators = append(translators, fb2Person("translator", c))
+		}
+	}
+	if len(authors) == 0 {
+		authors = append(authors, fb2Person("author", Contributor{Name: "Unknown"}))
+	}
+	dom.Append(titleInfo, authors...)
+	dom.Append(titleInfo, dom.Elem("book-title", dom.Text(info.Title)))
+	if info.Comments != "" {
+		annotation := dom.Elem("annotation")
+		for _, p := range strings.Split(info.Comments, "\n\n") {
+			dom.Append(annotation, dom.Elem("p", dom.Text(p)))
+		}
+		dom.Append(titleInfo, annotation)
+	}
+	var date *Node
+	if !info.Modified.IsZero() {
+		day := info.Modified.Format("2006-01-02")
+		date = dom.Element("date", dom.Attr{"value": day}, dom.Text(day))
+		dom.Append(titleInfo, dom.Clone(date))
+	}
+	if len(cover) > 0 {
+		dom.Append(titleInfo, dom.Elem("coverpage", dom.Element("image", dom.Attr{"l:href": "#cover.jpg"})))
+	}
+	dom.Append(titleInfo, dom.Elem("lang", dom.Text(info.Language)))
+	dom.Append(titleInfo, translators...)
+	if info.Series != "" {
+		sequence := dom.Element("sequence", dom.Attr{"name": info.Series})
+		if index := info.SeriesIndex; index > 0 && index == math.Trunc(index) {
+			dom.AddAttribute(sequence, "number", formatSeriesIndex(index))
+		}
+		dom.Append(titleInfo, sequence)
+	}
+
+	documentInfo := dom.Elem("document-info")
+	for _, author := range authors {
+		dom.Append(documentInfo, dom.Clone(author))
+	}
+	dom.Append(documentInfo, dom.Elem("program-used", dom.Text("github.com/HalCanary/facility/ebook")))
+	if date == nil {
+		date = dom.Elem("date")
+	}
+	dom.Append(documentInfo, date)
+	if info.Source != "" {
+		dom.Append(documentInfo, dom.Elem("src-url", dom.Text(info.Source)))
+	}
+	dom.Append(documentInfo,
+		dom.Elem("id", dom.Text(info.BookIdentifier())),
+		dom.Elem("version", dom.Text("1.0")))
+
+	body := dom.Elem("body", dom.Elem("title", dom.Elem("p", dom.Text(info.Title))))
+	dom.Append(body, fb2Sections(info, makeToc(info.Chapters))...)
+
+	book := dom.Element("FictionBook",
+		dom.Attr{
+			"xmlns":   "http://www.gribuser.ru/xml/fictionbook/2.0",
+			"xmlns:l": "http://www.w3.org/1999/xlink",
+		},
+		dom.Elem("description", titleInfo, documentInfo),
+		body,
+	)
+	if len(cover) > 0 {
+		dom.Append(book, dom.Element("binary", dom.Attr{"id": "cover.jpg", "content-type": "image/jpeg"},
+			dom.Text(base64.StdEncoding.EncodeToString(cover))))
+	}
+
+	if _, err := io.WriteString(dst, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(dst)
+	if err := encodeFb2(encoder, book); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(xml.CharData("\n")); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+// Return a FictionBook author or translator element.
+func fb2Person(tag string, c Contributor) *Node {
+	if last, first, found := strings.Cut(c.FileAs, ", "); found {
+		return dom.Elem(tag,
+			dom.Elem("first-name", dom.Text(first)),
+			dom.Elem("last-name", dom.Text(last)))
+	}
+	names := strings.Fields(c.Name)
+	if len(names) < 2 {
+		return dom.Elem(tag, dom.Elem("nickname", dom.Text(c.Name)))
+	}
+	return dom.Elem(tag,
+		dom.Elem("first-name", dom.Text(strings.Join(names[:len(names)-1], " "))),
+		dom.Elem("last-name", dom.Text(names[len(names)-1])))
+}
+
+func fb2Sections(info EbookInfo, entries []*tocEntry) []*Node {
+	var result []*Node
+	for _, e := range entries {
+		section := dom.Elem("section", dom.Elem("title", dom.Elem("p", dom.Text(e.label()))))
+		if e.Chapter < 0 {
+			dom.Append(section, fb2Sections(info, e.Children)...)
+		} else if content := info.Chapters[e.Chapter].Content; content != nil {
+			var converter fb2Converter
+			converter.blocks(content)
+			converter.flush()
+			dom.Append(section, converter.result...)
+		}
+		if section.FirstChild == section.LastChild {
+			dom.Append(section, dom.Elem("empty-line"))
+		}
+		result = append(result, section)
+	}
+	return result
+}
+
+// Converts HTML content to FictionBook elements.
+type fb2Converter struct {
+	result    []*Node
+	paragraph *Node // The paragraph that inline content is added to, if any.
+}
+
+// End the current paragraph.
+func (c *fb2Converter) flush() {
+	if p := c.paragraph; p != nil {
+		c.paragraph = nil
+		if first := p.FirstChild; first != nil && first.Type == dom.TextNode {
+			first.Data = strings.TrimLeft(first.Data, " ")
+		}
+		if last := p.LastChild; last != nil && last.Type == dom.TextNode {
+			last.Data = strings.TrimRight(last.Data, " ")
+		}
+		if strings.TrimSpace(dom.ExtractText(p)) != "" {
+			c.result = append(c.result, p)
+		}
+	}
+}
+
+// Convert the children of `node`.
+func (c *fb2Converter) blocks(node *Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != dom.ElementNode || !isBlockElement(child.Data) {
+			if c.paragraph == nil {
+				c.paragraph = dom.Elem("p")
+			}
+			c.inline(child, c.paragraph)
+			continue
+		}
+		c.flush()
+		switch child.Data {
+		case "script", "style", "head", "title":
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			subtitle := dom.Elem("subtitle")
+			c.inline(child, subtitle)
+			if text := strings.TrimSpace(dom.ExtractText(subtitle)); text != "" {
+				c.result = append(c.result, subtitle)
+			}
+		case "hr":
+			c.result = append(c.result, dom.Elem("subtitle", dom.Text("* * *")))
+		case "pre":
+			for _, line := range strings.Split(strings.Trim(rawText(child), "\n"), "\n") {
+				if line == "" {
+					c.result = append(c.result, dom.Elem("empty-line"))
+				} else {
+					c.result = append(c.result, dom.Elem("p", dom.Elem("code", dom.Text(line))))
+				}
+			}
+		case "blockquote":
+			var quoted fb2Converter
+			quoted.blocks(child)
+			quoted.flush()
+			if len(quoted.result) > 0 {
+				c.result = append(c.result, dom.Elem("cite", quoted.result...))
+			}
+		case "ul", "ol":
+			c.list(child)
+		case "table":
+			c.table(child)
+		default:
+			c.blocks(child)
+			c.flush()
+		}
+	}
+}
+
+func (c *fb2Converter) list(node *Node) {
+	number := 1
+	if start, err := strconv.Atoi(dom.GetAttribute(node, "start")); err == nil {
+		number = start
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if !isElement(child, "li") {
+			continue
+		}
+		marker := "• "
+		if node.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		start := len(c.result)
+		c.blocks(child)
+		c.flush()
+		if start < len(c.result) && c.result[start].Data == "p" {
+			p := c.result[start]
+			p.InsertBefore(dom.Text(marker), p.FirstChild)
+		}
+	}
+}
+
+func (c *fb2Converter) table(node *Node) {
+	table := dom.Elem("table")
+	for _, tr := range dom.FindNodesByTagAndAttrib(node, "tr", "", "") {
+		row := dom.Elem("tr")
+		for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+			if isElement(cell, "td") || isElement(cell, "th") {
+				converted := dom.Elem(cell.Data)
+				c.inline(cell, converted)
+				dom.Append(row, converted)
+			}
+		}
+		if row.FirstChild != nil {
+			dom.Append(table, row)
+		}
+	}
+	if table.FirstChild != nil {
+		c.result = append(c.result, table)
+	}
+}
+
+var fb2InlineElements = map[string]string{
+	"em": "emphasis", "i": "emphasis", "cite": "emphasis", "dfn": "emphasis", "var": "emphasis",
+	"strong": "strong", "b": "strong",
+	"del": "strikethrough", "s": "strikethrough", "strike": "strikethrough",
+	"sub": "sub", "sup": "sup",
+	"code": "code", "kbd": "code", "samp": "code", "tt": "code",
+}
+
+// Append the converted inline content of `node` to `dst`.  Line breaks start
+// a new paragraph when `dst` is the current paragraph.
+func (c *fb2Converter) inline(node *Node, dst *Node) {
+	switch node.Type {
+	case dom.TextNode:
+		dom.Append(dst, dom.Text(whitespaceRegexp.ReplaceAllString(node.Data, " ")))
+		return
+	case dom.ElementNode:
+	default:
+		return
+	}
+	switch node.Data {
+	case "br":
+		if dst == c.paragraph {
+			c.flush()
+			c.paragraph = dom.Elem("p")
+		} else {
+			dom.Append(dst, dom.Text(" "))
+		}
+		return
+	case "img":
+		if alt := dom.GetAttribute(node, "alt"); alt != "" {
+			dom.Append(dst, dom.Text("["+alt+"]"))
+		}
+		return
+	case "script", "style":
+		return
+	case "a":
+		if href := dom.GetAttribute(node, "href"); strings.Contains(href, ":") {
+			a := dom.Element("a", dom.Attr{"l:href": href})
+			dom.Append(dst, a)
+			dst = a
+		}
+	default:
+		if tag, ok := fb2InlineElements[node.Data]; ok {
+			element := dom.Elem(tag)
+			dom.Append(dst, element)
+			dst = element
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		c.inline(child, dst)
+		if dst.Data == "p" && dst != c.paragraph && c.paragraph != nil {
+			// A line break ended the paragraph; continue in the new one.
+			dst = c.paragraph
+		}
+	}
+}
+
+// Encode the element and its descendants as XML tokens.
+func encodeFb2(encoder *xml.Encoder, node *Node) error {
+	switch node.Type {
+	case dom.TextNode:
+		return encoder.EncodeToken(xml.CharData(node.Data))
+	case dom.ElementNode:
+	default:
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: node.Data}}
+	for _, attr := range node.Attr {
+		name := attr.Key
+		if attr.Namespace != "" {
+			name = attr.Namespace + ":" + attr.Key
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: attr.Val})
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if fb2BlockElements[node.Data] && node.FirstChild != nil && fb2BlockElements[node.FirstChild.Data] && node.FirstChild.Type == dom.ElementNode {
+		if err := encoder.EncodeToken(xml.CharData("\n")); err != nil {
+			return err
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if err := encodeFb2(encoder, child); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	if fb2BlockElements[node.Data] && node.Parent != nil && node.Parent.Data != "p" {
+		return encoder.EncodeToken(xml.CharData("\n"))
+	}
+	return nil
+}
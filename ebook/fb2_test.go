@@ -0,0 +1,67 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestWriteFB2(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Contributors = []Contributor{
+		{Name: "Jane Austen", FileAs: "Austen, Jane"},
+		{Name: "Anon"},
+		{Name: "John Q. Doe", Role: RoleTranslator},
+	}
+	book.Series, book.SeriesIndex = "Stories", 2
+	var cover bytes.Buffer
+	png.Encode(&cover, image.NewGray(image.Rect(0, 0, 40, 60)))
+	book.Cover = cover.Bytes()
+	book.Chapters[0].Sections = []string{"Part 1"}
+	doc, err := dom.Parse(strings.NewReader(`<div><p>Some <em>emphasis</em> and
+<strong>strong</strong><br/>text with a <a href="https://example.com/">link</a>.</p>
+<blockquote>quoted</blockquote><ol><li>first</li></ol></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Chapters[1].Content = dom.FindNodeByTag(doc, "div")
+	var buffer bytes.Buffer
+	if err := book.WriteFB2(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	result := buffer.String()
+	for _, s := range []string{
+		`<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">`,
+		"<author>\n<first-name>Jane</first-name>\n<last-name>Austen</last-name>\n</author>\n<author>\n<nickname>Anon</nickname>\n</author>",
+		"<translator>\n<first-name>John Q.</first-name>\n<last-name>Doe</last-name>\n</translator>",
+		`<sequence name="Stories" number="2"></sequence>`,
+		`<coverpage>` + "\n" + `<image l:href="#cover.jpg"></image>`,
+		"<section>\n<title>\n<p>Part 1</p>\n</title>\n<section>\n<title>\n<p>1. One</p>\n</title>",
+		"<p>Some <emphasis>emphasis</emphasis> and <strong>strong</strong></p>\n" +
+			`<p>text with a <a l:href="https://example.com/">link</a>.</p>` + "\n" +
+			"<cite>\n<p>quoted</p>\n</cite>\n<p>1. first</p>",
+		`<binary content-type="image/jpeg" id="cover.jpg">`,
+	} {
+		if !strings.Contains(result, s) {
+			t.Errorf("missing %q", s)
+		}
+	}
+	decoder := xml.NewDecoder(strings.NewReader(result))
+	for {
+		_, err := decoder.Token()
+		if err != nil {
+			expect.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"html"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Returned by ParseFeed when the data is not an RSS 2.0 or Atom feed.
+var NotAFeedError = errors.New("not an RSS or Atom feed")
+
+// The priority of the feed generator: it is tried after every other generator.
+const FeedGeneratorPriority = math.MinInt32
+
+// The URLs tried as feeds: web addresses that look like feeds, "file:" URLs,
+// and file paths.
+var feedUrlPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^https?://[^?#]*(/feeds?|/rss|/atom|\.rss|\.atom|\.xml)(/[^?#]*)?([?#].*)?$`),
+	regexp.MustCompile(`(?i)^https?://[^#]*[?&](feed|format)=(rss|atom)`),
+	regexp.MustCompile(`^file:`),
+	regexp.MustCompile(`^([^:]*|[A-Za-z]:[\\/].*)$`),
+}
+
+func init() {
+	RegisterNamedGenerator(NamedGenerator{
+		Name:        "feed",
+		Description: "RSS 2.0 and Atom feeds, and feed files",
+		Patterns:    feedUrlPatterns,
+		Priority:    FeedGeneratorPriority,
+		Generator:   feedGenerator{},
+	})
+}
+
+type rssFeed struct {
+	Channel struct {
+		Title         string    `xml:"title"`
+		Description   string    `xml:"description"`
+		Language      string    `xml:"language"`
+		Editor        string    `xml:"managingEditor"`
+		Creator       string    `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		PubDate       string    `xml:"pubDate"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Image         string    `xml:"image>url"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Links       []string `xml:"link"` // Includes empty `atom:link` elements.
+	Guid        string   `xml:"guid"`
+	Description string   `xml:"description"`
+	Encoded     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomText struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// Return the text as HTML.
+func (t atomText) html() string {
+	switch t.Type {
+	case "xhtml":
+		return t.InnerXML
+	case "html":
+		return t.Text
+	}
+	return html.EscapeString(t.Text)
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomFeed struct {
+	Title    atomText     `xml:"title"`
+	Subtitle atomText     `xml:"subtitle"`
+	Lang     string       `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Updated  string       `xml:"updated"`
+	Authors  []atomPerson `xml:"author"`
+	Logo     string       `xml:"logo"`
+	Entries  []atomEntry  `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     atomText   `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Id        string     `xml:"id"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published"`
+	Content   atomText   `xml:"content"`
+	Summary   atomText   `xml:"summary"`
+}
+
+// Return the alternate link, which is the default.
+func alternateLink(links []atomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// A chapter of a feed, with the date used to order it.
+type feedEntry struct {
+	Chapter
+	date time.Time
+}
+
+// Parse an RSS 2.0 or Atom feed into an ebook: one chapter for each entry, in
+// chronological order, with the metadata of the feed.  Entries without
+// content have a nil Content.  `feedUrl` is used to resolve relative links
+// and becomes the Source of the book.
+func ParseFeed(data []byte, feedUrl string) (EbookInfo, error) {
+	info, _, err := parseFeed(data, feedUrl)
+	return info, err
+}
+
+// Parse a feed; also return the URL of its image.
+func parseFeed(data []byte, feedUrl string) (EbookInfo, string, error) {
+	base, err := url.Parse(feedUrl)
+	if err != nil {
+		return EbookInfo{}, "", err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return EbookInfo{}, "", NotAFeedError
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+	info := EbookInfo{Source: feedUrl}
+	var cover string
+	var entries []feedEntry
+	switch {
+	case root.Name.Local == "rss":
+		var feed rssFeed
+		if err := decoder.DecodeElement(&feed, &root); err != nil {
+			return EbookInfo{}, "", err
+		}
+		channel := feed.Channel
+		info.Title = strings.TrimSpace(channel.Title)
+		info.Comments = htmlToText(channel.Description)
+		info.Language = strings.TrimSpace(channel.Language)
+		info.Authors = strings.TrimSpace(channel.Creator)
+		if info.Authors == "" {
+			info.Authors = rssPerson(channel.Editor)
+		}
+		info.Modified = parseFeedDate(channel.LastBuildDate, channel.PubDate)
+		cover = resolveUrl(base, strings.TrimSpace(channel.Image))
+		for _, item := range channel.Items {
+			link := firstNonEmpty(append(item.Links, item.Guid)...)
+			entry := feedEntry{Chapter: Chapter{
+				Title:    strings.TrimSpace(item.Title),
+				Url:      resolveUrl(base, strings.TrimSpace(link)),
+				Modified: parseFeedDate(item.PubDate, item.Date),
+				Content:  parseFeedContent(firstNonEmpty(item.Encoded, item.Description)),
+			}}
+			entry.date = entry.Modified
+			entries = append(entries, entry)
+		}
+	case root.Name.Local == "feed" && root.Name.Space == "http://www.w3.org/2005/Atom":
+		var feed atomFeed
+		if err := decoder.DecodeElement(&feed, &root); err != nil {
+			return EbookInfo{}, "", err
+		}
+		info.Title = htmlToText(feed.Title.html())
+		info.Comments = htmlToText(feed.Subtitle.html())
+		info.Language = feed.Lang
+		var authors []string
+		for _, author := range feed.Authors {
+			if name := strings.TrimSpace(author.Name); name != "" {
+				authors = append(authors, name)
+			}
+		}
+		info.Authors = strings.Join(authors, ", ")
+		info.Modified = parseFeedDate(feed.Updated)
+		cover = resolveUrl(base, strings.TrimSpace(feed.Logo))
+		for _, e := range feed.Entries {
+			entry := feedEntry{Chapter: Chapter{
+				Title:    htmlToText(e.Title.html()),
+				Url:      resolveUrl(base, strings.TrimSpace(firstNonEmpty(alternateLink(e.Links), e.Id))),
+				Modified: parseFeedDate(e.Updated, e.Published),
+				Content:  parseFeedContent(firstNonEmpty(e.Content.html(), e.Summary.html())),
+			}}
+			entry.date = parseFeedDate(e.Published, e.Updated)
+			entries = append(entries, entry)
+		}
+	default:
+		return EbookInfo{}, "", NotAFeedError
+	}
+
+	// Feeds list the newest entries first; put the oldest first.
+	dated := true
+	for _, entry := range entries {
+		dated = dated && !entry.date.IsZero()
+	}
+	if dated {
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].date.Before(entries[j].date) })
+	} else {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	for _, entry := range entries {
+		info.Chapters = append(info.Chapters, entry.Chapter)
+		if entry.Modified.After(info.Modified) {
+			info.Modified = entry.Modified
+		}
+	}
+	return info, cover, nil
+}
+
+// Return the first argument that is not empty or whitespace.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Return the name from an RSS person, such as "joe@example.com (Joe Smith)".
+func rssPerson(s string) string {
+	s = strings.TrimSpace(s)
+	if open := strings.Index(s, "("); open >= 0 && strings.HasSuffix(s, ")") {
+		return strings.TrimSpace(s[open+1 : len(s)-1])
+	}
+	return s
+}
+
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Return the time of the first date that can be parsed, or the zero time.
+func parseFeedDate(dates ...string) time.Time {
+	for _, date := range dates {
+		date = strings.TrimSpace(date)
+		if date == "" {
+			continue
+		}
+		for _, layout := range feedDateLayouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Parse HTML into the children of a div; return nil if there is no content.
+func parseFeedContent(source string) *Node {
+	if strings.TrimSpace(source) == "" {
+		return nil
+	}
+	div := dom.Elem("div")
+	div.DataAtom = atom.Div
+	nodes, err := dom.ParseFragment(strings.NewReader(source), div)
+	if err != nil {
+		return nil
+	}
+	return dom.Append(div, nodes...)
+}
+
+// Return the text of a HTML fragment.
+func htmlToText(source string) string {
+	content := parseFeedContent(source)
+	if content == nil {
+		return ""
+	}
+	return strings.TrimSpace(whitespaceRegexp.ReplaceAllString(dom.ExtractText(content), " "))
+}
+
+// Return the main content of a page.
+func pageContent(doc *Node) *Node {
+	for _, tag := range []string{"article", "main", "body"} {
+		if node := dom.FindNodeByTag(doc, tag); node != nil {
+			return dom.Remove(node)
+		}
+	}
+	return doc
+}
+
+// Generates ebooks from feeds.
+type feedGenerator struct {
+	fetch PageFetcher // If nil, `getPage`.
+}
+
+// Read a feed from a file path, a "file:" URL, or the web.
+func readFeed(ctx context.Context, fetch PageFetcher, feedUrl string, force bool) ([]byte, string, error) {
+	u, err := url.Parse(feedUrl)
+	if err != nil {
+		return nil, "", UnsupportedUrlError
+	}
+	switch u.Scheme {
+	case "http", "https":
+		data, err := fetch(ctx, feedUrl, "", force)
+		return data, feedUrl, err
+	case "file":
+		data, err := os.ReadFile(u.Path)
+		return data, feedUrl, err
+	case "":
+		path, err := filepath.Abs(feedUrl)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, "", UnsupportedUrlError
+		}
+		data, err := os.ReadFile(path)
+		return data, (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), err
+	}
+	return nil, "", UnsupportedUrlError
+}
+
+// Generate an ebook from a feed.  Returns UnsupportedUrlError if the URL is
+// not a feed.  Entries without content are downloaded from their links.
+func (g feedGenerator) GenerateEbook(ctx context.Context, feedUrl string, options GeneratorOptions) (EbookInfo, error) {
+	fetch := g.fetch
+	if fetch == nil {
+		fetch = getPage
+	}
+	data, source, err := readFeed(ctx, fetch, feedUrl, options.ForceDownload(true))
+	if err != nil {
+		return EbookInfo{}, err
+	}
+	info, coverUrl, err := parseFeed(data, source)
+	if err == NotAFeedError {
+		return EbookInfo{}, UnsupportedUrlError
+	} else if err != nil {
+		return EbookInfo{}, err
+	}
+	if strings.HasPrefix(coverUrl, "http") {
+		var coverErr error
+		if info.Cover, coverErr = fetch(ctx, coverUrl, source, false); coverErr != nil {
+			log.Printf("Cover error: %s: %v", coverUrl, coverErr)
+		}
+	}
+	var chapters []Chapter
+	for i, chapter := range info.Chapters {
+		if options.IncludesChapter(i) {
+			chapters = append(chapters, chapter)
+		}
+	}
+	info.Chapters = chapters
+	if !options.Populate {
+		return info, nil
+	}
+
+	var missing []int
+	var urls []string
+	for i, chapter := range info.Chapters {
+		if chapter.Content == nil && strings.HasPrefix(chapter.Url, "http") {
+			missing = append(missing, i)
+			urls = append(urls, chapter.Url)
+		}
+	}
+	if len(urls) > 0 {
+		pages, err := FetchChapters(ctx, urls, func(pageUrl string, doc *Node) (Chapter, error) {
+			return Chapter{Content: pageContent(doc)}, nil
+		}, FetchOptions{
+			Referer:  source,
+			Force:    options.ForceDownload(false),
+			Fetch:    g.fetch,
+			Progress: options.Progress,
+		})
+		if err != nil {
+			return EbookInfo{}, err
+		}
+		for i, page := range pages {
+			info.Chapters[missing[i]].Content = page.Content
+		}
+	}
+	info.Cleanup()
+	return info, nil
+}
@@ -0,0 +1,185 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+const testRssFeed = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+	<title>A Serial</title>
+	<atom:link href="https://example.com/feed.xml" rel="self" type="application/rss+xml"/>
+	<link>https://example.com/</link>
+	<description>A story &lt;em&gt;told&lt;/em&gt; in parts.</description>
+	<language>en-us</language>
+	<managingEditor>ann@example.com (Ann Author)</managingEditor>
+	<lastBuildDate>Tue, 03 May 2022 10:00:00 GMT</lastBuildDate>
+	<image><url>/cover.png</url><title>A Serial</title><link>https://example.com/</link></image>
+	<item>
+		<title>Part Two</title>
+		<link>https://example.com/2</link>
+		<pubDate>Mon, 2 May 2022 10:00:00 +0000</pubDate>
+		<description>&lt;p&gt;Summary of two.&lt;/p&gt;</description>
+		<content:encoded><![CDATA[<p>Two, caf` + "\xe9" + `.</p>]]></content:encoded>
+	</item>
+	<item>
+		<title>Part Three</title>
+		<guid>https://example.com/3</guid>
+		<pubDate>Tue, 03 May 2022 10:00:00 GMT</pubDate>
+	</item>
+	<item>
+		<title>Part One</title>
+		<link>/1</link>
+		<pubDate>Sun, 01 May 2022 10:00:00 GMT</pubDate>
+		<description>&lt;p&gt;One.&lt;/p&gt;</description>
+	</item>
+</channel>
+</rss>`
+
+const testAtomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xml:lang="fr">
+	<title type="html">Le &lt;i&gt;Journal&lt;/i&gt;</title>
+	<subtitle>Notes</subtitle>
+	<updated>2022-05-01T00:00:00Z</updated>
+	<author><name>Bea</name></author>
+	<author><name>Cal</name></author>
+	<entry>
+		<title>Second</title>
+		<link rel="edit" href="https://example.net/edit/2"/>
+		<link href="https://example.net/2"/>
+		<id>tag:example.net,2022:2</id>
+		<published>2022-05-02T00:00:00Z</published>
+		<updated>2022-06-01T00:00:00Z</updated>
+		<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Deux.</p></div></content>
+	</entry>
+	<entry>
+		<title>First</title>
+		<link rel="alternate" href="/1"/>
+		<published>2022-05-01T00:00:00Z</published>
+		<summary>1 &lt; 2</summary>
+	</entry>
+</feed>`
+
+func chapterText(chapter Chapter) string {
+	return strings.TrimSpace(dom.ExtractText(chapter.Content))
+}
+
+func TestParseFeedRss(t *testing.T) {
+	info, err := ParseFeed([]byte(testRssFeed), "https://example.com/feed.xml")
+	expect.True(t, err == nil)
+	expect.Equal(t, "A Serial", info.Title)
+	expect.Equal(t, "A story told in parts.", info.Comments)
+	expect.Equal(t, "en-us", info.Language)
+	expect.Equal(t, "Ann Author", info.Authors)
+	expect.Equal(t, "https://example.com/feed.xml", info.Source)
+	expect.Equal(t, time.Date(2022, 5, 3, 10, 0, 0, 0, time.UTC), info.Modified.UTC())
+	expect.Equal(t, 3, len(info.Chapters))
+
+	expect.Equal(t, "Part One", info.Chapters[0].Title)
+	expect.Equal(t, "https://example.com/1", info.Chapters[0].Url)
+	expect.Equal(t, "One.", chapterText(info.Chapters[0]))
+	expect.Equal(t, "Part Two", info.Chapters[1].Title)
+	expect.Equal(t, "Two, café.", chapterText(info.Chapters[1]))
+	expect.Equal(t, time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC), info.Chapters[1].Modified.UTC())
+	expect.Equal(t, "https://example.com/3", info.Chapters[2].Url)
+	expect.True(t, info.Chapters[2].Content == nil)
+}
+
+func TestParseFeedAtom(t *testing.T) {
+	info, err := ParseFeed([]byte(testAtomFeed), "https://example.net/feed")
+	expect.True(t, err == nil)
+	expect.Equal(t, "Le Journal", info.Title)
+	expect.Equal(t, "Notes", info.Comments)
+	expect.Equal(t, "fr", info.Language)
+	expect.Equal(t, "Bea, Cal", info.Authors)
+	expect.Equal(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), info.Modified)
+	expect.Equal(t, 2, len(info.Chapters))
+
+	expect.Equal(t, "First", info.Chapters[0].Title)
+	expect.Equal(t, "https://example.net/1", info.Chapters[0].Url)
+	expect.Equal(t, "1 < 2", chapterText(info.Chapters[0]))
+	expect.Equal(t, "Second", info.Chapters[1].Title)
+	expect.Equal(t, "https://example.net/2", info.Chapters[1].Url)
+	expect.Equal(t, "Deux.", chapterText(info.Chapters[1]))
+	expect.Equal(t, time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), info.Chapters[1].Modified)
+
+	for _, data := range []string{"", "<html><body>Hi</body></html>", "<feed><title>Not Atom</title></feed>"} {
+		_, err = ParseFeed([]byte(data), "https://example.net/feed")
+		expect.True(t, err == NotAFeedError)
+	}
+}
+
+func TestFeedGenerator(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feed.atom")
+	expect.True(t, os.WriteFile(path, []byte(testAtomFeed), 0o644) == nil)
+
+	generator, ok := GeneratorFor(path)
+	expect.True(t, ok)
+	expect.Equal(t, "feed", generator.Name)
+
+	info, err := DownloadEbookContext(context.Background(), path, GeneratorOptions{Populate: true, FirstChapter: 2})
+	expect.True(t, err == nil)
+	expect.Equal(t, "Le Journal", info.Title)
+	expect.True(t, strings.HasPrefix(info.Source, "file:///"))
+	expect.Equal(t, 1, len(info.Chapters))
+	expect.Equal(t, "Deux.", chapterText(info.Chapters[0]))
+
+	_, err = feedGenerator{}.GenerateEbook(context.Background(), filepath.Join(filepath.Dir(path), "missing"), GeneratorOptions{})
+	expect.True(t, err == UnsupportedUrlError)
+
+	for _, feedUrl := range []string{
+		"https://example.com/feed", "https://example.com/blog/feed/", "https://example.com/rss.xml",
+		"https://example.com/feeds/posts/default?alt=rss", "https://example.com/index.php?format=atom",
+		"file:///tmp/feed.xml", "feed.atom", `C:\feeds\a.xml`,
+	} {
+		expect.True(t, generator.Matches(feedUrl))
+	}
+	for _, pageUrl := range []string{
+		"https://example.com/", "https://example.com/story/1", "https://example.com/feedback", "ftp://example.com/feed",
+	} {
+		expect.True(t, !generator.Matches(pageUrl))
+	}
+}
+
+func TestFeedGeneratorFetchesPages(t *testing.T) {
+	var fetched []string
+	generator := feedGenerator{fetch: func(ctx context.Context, url, referer string, force bool) ([]byte, error) {
+		fetched = append(fetched, url)
+		switch url {
+		case "https://example.com/feed.xml":
+			return []byte(testRssFeed), nil
+		case "https://example.com/cover.png":
+			return []byte("cover"), nil
+		case "https://example.com/3":
+			expect.Equal(t, "https://example.com/feed.xml", referer)
+			return []byte(`<html><body><nav>Menu</nav><article><p>Three.</p></article></body></html>`), nil
+		}
+		return nil, errors.New("not found: " + url)
+	}}
+	info, err := generator.GenerateEbook(context.Background(), "https://example.com/feed.xml", GeneratorOptions{})
+	expect.True(t, err == nil)
+	expect.Equal(t, "cover", string(info.Cover))
+	expect.Equal(t, 3, len(info.Chapters))
+	expect.True(t, info.Chapters[2].Content == nil)
+	expect.DeepEqual(t, []string{"https://example.com/feed.xml", "https://example.com/cover.png"}, fetched)
+
+	info, err = generator.GenerateEbook(context.Background(), "https://example.com/feed.xml", GeneratorOptions{Populate: true})
+	expect.True(t, err == nil)
+	expect.Equal(t, "Part Three", info.Chapters[2].Title)
+	expect.Equal(t, "Three.", chapterText(info.Chapters[2]))
+	expect.Equal(t, "One.", chapterText(info.Chapters[0]))
+	expect.Equal(t, "https://example.com/3", fetched[len(fetched)-1])
+}
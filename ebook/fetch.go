@@ -0,0 +1,242 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/download"
+)
+
+// Downloads a page.  `force` asks for a fresh copy rather than a cached one.
+type PageFetcher func(ctx context.Context, url, referer string, force bool) ([]byte, error)
+
+// Makes a chapter from a downloaded page.  `FetchChapters` calls it from
+// several goroutines at once, so it must be safe to call concurrently.
+type ChapterParser func(url string, doc *Node) (Chapter, error)
+
+// Defaults for `FetchOptions`.
+const (
+	DefaultFetchWorkers    = 4
+	DefaultFetchInterval   = 500 * time.Millisecond
+	DefaultFetchRetries    = 3
+	DefaultFetchRetryDelay = time.Second
+)
+
+// Settings for `FetchChapters`.  The zero value gives the defaults.
+type FetchOptions struct {
+	// The number of pages downloaded at once.  Zero means DefaultFetchWorkers.
+	Workers int
+	// The least time between requests to the same host.  Zero means
+	// DefaultFetchInterval; negative means no limit.
+	HostInterval time.Duration
+	// How many times a page is tried again after a temporary failure.  Zero
+	// means DefaultFetchRetries; negative means none.
+	Retries int
+	// The wait before the first retry, doubled for each further retry.  Zero
+	// means DefaultFetchRetryDelay.
+	RetryDelay time.Duration
+	// Sent as the referer of every request.
+	Referer string
+	// Download fresh copies of pages rather than cached ones.
+	Force bool
+	// Used to download pages.  If nil, pages are downloaded with
+	// `download.GetUrlContext`, and cached pages are not rate limited.
+	Fetch PageFetcher
+	// If not nil, called as each chapter is done, with the number of
+	// chapters done, the total, and the title of the chapter.
+	Progress func(done, total int, message string)
+}
+
+func (o FetchOptions) withDefaults() FetchOptions {
+	if o.Workers <= 0 {
+		o.Workers = DefaultFetchWorkers
+	}
+	if o.HostInterval == 0 {
+		o.HostInterval = DefaultFetchInterval
+	}
+	if o.Retries == 0 {
+		o.Retries = DefaultFetchRetries
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = DefaultFetchRetryDelay
+	}
+	return o
+}
+
+// Download and parse the chapters at `urls` concurrently.  The chapters are
+// returned in the order of `urls`; a chapter with no Url gets the one it was
+// downloaded from.  If any chapter fails, the others are cancelled and the
+// error of the first failure is returned.  `parse` and `options.Fetch` are
+// called concurrently, from `options.Workers` goroutines.
+func FetchChapters(ctx context.Context, urls []string, parse ChapterParser, options FetchOptions) ([]Chapter, error) {
+	options = options.withDefaults()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limiter := hostLimiter{interval: options.HostInterval, next: map[string]time.Time{}}
+	chapters := make([]Chapter, len(urls))
+	errs := make([]error, len(urls))
+	jobs := make(chan int)
+	results := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < options.Workers && w < len(urls); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				chapters[i], errs[i] = fetchChapter(ctx, urls[i], parse, options, &limiter)
+				results <- i
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range urls {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	done := 0
+	for i := range results {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+				cancel()
+			}
+			continue
+		}
+		done++
+		if options.Progress != nil && firstErr == nil {
+			options.Progress(done, len(urls), chapters[i].Title)
+		}
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return chapters, nil
+}
+
+// Download and parse one chapter, trying again after temporary failures.
+func fetchChapter(ctx context.Context, pageUrl string, parse ChapterParser, options FetchOptions, limiter *hostLimiter) (Chapter, error) {
+	fetch := options.Fetch
+	limited := true
+	if fetch == nil {
+		fetch = getPage
+		limited = options.Force || !download.IsCached(pageUrl)
+	}
+	host := pageUrl
+	if u, err := url.Parse(pageUrl); err == nil {
+		host = u.Host
+	}
+	var data []byte
+	for attempt := 0; ; attempt++ {
+		if limited {
+			if err := limiter.wait(ctx, host); err != nil {
+				return Chapter{}, err
+			}
+		}
+		var err error
+		if data, err = fetch(ctx, pageUrl, options.Referer, options.Force); err == nil {
+			break
+		}
+		if attempt >= options.Retries || ctx.Err() != nil || !isTemporary(err) {
+			return Chapter{}, fmt.Errorf("%s: %w", pageUrl, err)
+		}
+		select {
+		case <-time.After(options.RetryDelay << attempt):
+		case <-ctx.Done():
+			return Chapter{}, ctx.Err()
+		}
+	}
+	doc, err := dom.Parse(bytes.NewReader(data))
+	if err != nil {
+		return Chapter{}, fmt.Errorf("%s: %w", pageUrl, err)
+	}
+	chapter, err := parse(pageUrl, doc)
+	if err != nil {
+		return Chapter{}, fmt.Errorf("%s: %w", pageUrl, err)
+	}
+	if chapter.Url == "" {
+		chapter.Url = pageUrl
+	}
+	return chapter, nil
+}
+
+func getPage(ctx context.Context, url, referer string, force bool) ([]byte, error) {
+	r, err := download.GetUrlContext(ctx, url, referer, force)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Return true if the error may go away if the request is tried again: a
+// temporary HTTP status, a timeout, or a refused or reset connection.  Errors
+// such as unknown hosts, bad certificates, and unsupported schemes are not.
+func isTemporary(err error) bool {
+	var statusErr *download.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Spaces out requests to each host.
+type hostLimiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	next     map[string]time.Time // The time of the next request to the host.
+}
+
+// Wait for the turn of a request to `host`.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return ctx.Err()
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mutex.Unlock()
+	if delay := slot.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err()
+}
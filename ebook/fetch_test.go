@@ -0,0 +1,115 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/download"
+	"github.com/HalCanary/facility/expect"
+)
+
+func parseTestChapter(url string, doc *Node) (Chapter, error) {
+	title := dom.ExtractText(dom.FindNodeByTag(doc, "h1"))
+	if title == "" {
+		return Chapter{}, errors.New("no title")
+	}
+	return Chapter{Title: title, Content: dom.FindNodeByTag(doc, "p")}, nil
+}
+
+func TestFetchChapters(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := map[string]int{}
+	fetch := func(ctx context.Context, url, referer string, force bool) ([]byte, error) {
+		expect.Equal(t, "https://example.com/", referer)
+		mutex.Lock()
+		attempts[url]++
+		n := attempts[url]
+		mutex.Unlock()
+		switch {
+		case strings.HasSuffix(url, "/flaky") && n < 3:
+			return nil, &download.StatusError{Url: url, StatusCode: 503, Status: "503 Service Unavailable"}
+		case strings.HasSuffix(url, "/missing"):
+			return nil, &download.StatusError{Url: url, StatusCode: 404, Status: "404 Not Found"}
+		}
+		name := url[strings.LastIndex(url, "/")+1:]
+		return []byte(fmt.Sprintf("<h1>%s</h1><p>Text of %s.</p>", name, name)), nil
+	}
+	options := FetchOptions{
+		Workers:      3,
+		HostInterval: -1,
+		RetryDelay:   time.Millisecond,
+		Referer:      "https://example.com/",
+		Fetch:        fetch,
+	}
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.com/%02d", i))
+	}
+	urls[7] = "https://example.com/flaky"
+	var reports []int
+	options.Progress = func(done, total int, message string) {
+		expect.Equal(t, 20, total)
+		reports = append(reports, done)
+	}
+	chapters, err := FetchChapters(context.Background(), urls, parseTestChapter, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, 20, len(chapters))
+	for i, chapter := range chapters {
+		expect.Equal(t, urls[i], chapter.Url)
+		expect.Equal(t, urls[i][strings.LastIndex(urls[i], "/")+1:], chapter.Title)
+	}
+	expect.Equal(t, 3, attempts["https://example.com/flaky"])
+	expect.Equal(t, 20, len(reports))
+	expect.Equal(t, 20, reports[19])
+
+	options.Progress = nil
+	urls[3] = "https://example.com/missing"
+	_, err = FetchChapters(context.Background(), urls, parseTestChapter, options)
+	expect.True(t, err != nil && strings.Contains(err.Error(), "404"))
+	expect.Equal(t, 1, attempts["https://example.com/missing"])
+	var statusErr *download.StatusError
+	expect.True(t, errors.As(err, &statusErr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FetchChapters(ctx, urls, parseTestChapter, options)
+	expect.True(t, errors.Is(err, context.Canceled))
+
+	// Requests to one host are spaced out.
+	start := time.Now()
+	chapters, err = FetchChapters(context.Background(), urls[4:7], parseTestChapter, FetchOptions{
+		Workers: 4, HostInterval: 20 * time.Millisecond, Referer: "https://example.com/", Fetch: fetch})
+	expect.True(t, err == nil && len(chapters) == 3)
+	expect.True(t, time.Since(start) >= 40*time.Millisecond)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTemporary(t *testing.T) {
+	_, err := getPage(context.Background(), "ftp://example.com/chapter", "", true)
+	expect.True(t, err != nil && !isTemporary(err))
+	expect.True(t, !isTemporary(&url.Error{Op: "Get", URL: "https://nowhere.invalid/", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}))
+	expect.True(t, !isTemporary(&download.StatusError{StatusCode: 404}))
+	expect.True(t, isTemporary(&download.StatusError{StatusCode: 503}))
+	expect.True(t, isTemporary(&url.Error{Op: "Get", URL: "https://example.com/", Err: timeoutError{}}))
+	expect.True(t, isTemporary(&url.Error{Op: "Get", URL: "https://example.com/", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}))
+}
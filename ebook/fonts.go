@@ -0,0 +1,185 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// A font embedded in a book.
+type Font struct {
+	Family string // The CSS font-family name, such as "Noto Serif".
+	Weight string // The CSS font-weight, such as "bold".  Optional.
+	Style  string // The CSS font-style, such as "italic".  Optional.
+	Data   []byte // A TrueType, OpenType, WOFF, or WOFF2 file.
+	// If true, the font is obfuscated in the Epub with the IDPF algorithm,
+	// keyed to the book identifier.
+	Obfuscate bool
+}
+
+var fontExtensions = map[string]string{
+	"font/otf":   ".otf",
+	"font/ttf":   ".ttf",
+	"font/woff":  ".woff",
+	"font/woff2": ".woff2",
+}
+
+// Return the media type of font data, if it is an EPUB core media type.
+func fontMediaType(data []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte("OTTO")):
+		return "font/otf", true
+	case bytes.HasPrefix(data, []byte("\x00\x01\x00\x00")), bytes.HasPrefix(data, []byte("true")):
+		return "font/ttf", true
+	case bytes.HasPrefix(data, []byte("wOFF")):
+		return "font/woff", true
+	case bytes.HasPrefix(data, []byte("wOF2")):
+		return "font/woff2", true
+	}
+	return "", false
+}
+
+// Return true if the media type is one used for fonts.
+func isFontMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "font/") || strings.HasPrefix(mediaType, "application/font-") ||
+		strings.HasPrefix(mediaType, "application/x-font-") || mediaType == "application/vnd.ms-opentype"
+}
+
+type epubFont struct {
+	Font
+	Href      string
+	MediaType string
+}
+
+// Return the fonts that can be stored in an Epub.  Others are logged and
+// dropped.
+func makeEpubFonts(fonts []Font) []epubFont {
+	var result []epubFont
+	for i, font := range fonts {
+		mediaType, ok := fontMediaType(font.Data)
+		if !ok {
+			log.Printf("Font error: %q: unknown font format", font.Family)
+			continue
+		}
+		result = append(result, epubFont{
+			Font:      font,
+			Href:      fmt.Sprintf("fonts/font%02d%s", i, fontExtensions[mediaType]),
+			MediaType: mediaType,
+		})
+	}
+	return result
+}
+
+// Apply the IDPF font obfuscation algorithm to `data`.  The algorithm is its
+// own inverse.
+func obfuscateFont(data []byte, identifier string) []byte {
+	key := sha1.Sum([]byte(strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, identifier)))
+	result := append([]byte(nil), data...)
+	for i := 0; i < len(result) && i < 1040; i++ {
+		result[i] ^= key[i%len(key)]
+	}
+	return result
+}
+
+const idpfObfuscation = "http://www.idpf.org/2008/embedding"
+
+// Write `META-INF/encryption.xml`, listing the obfuscated fonts.
+func writeEncryption(fonts []epubFont, dst io.Writer) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">` + "\n")
+	for _, font := range fonts {
+		if font.Obfuscate {
+			fmt.Fprintf(&b, " <enc:EncryptedData>\n"+
+				"  <enc:EncryptionMethod Algorithm=%q/>\n"+
+				"  <enc:CipherData>\n"+
+				"   <enc:CipherReference URI=%q/>\n"+
+				"  </enc:CipherData>\n"+
+				" </enc:EncryptedData>\n", idpfObfuscation, "book/"+font.Href)
+		}
+	}
+	b.WriteString("</encryption>\n")
+	_, err := io.WriteString(dst, b.String())
+	return err
+}
+
+// Return the paths of the files obfuscated with the IDPF algorithm, according
+// to `META-INF/encryption.xml`.
+func (r epubReader) obfuscatedFiles() (map[string]bool, error) {
+	result := map[string]bool{}
+	if r.files["META-INF/encryption.xml"] == nil {
+		return result, nil
+	}
+	var encryption struct {
+		Data []struct {
+			Method struct {
+				Algorithm string `xml:"Algorithm,attr"`
+			} `xml:"EncryptionMethod"`
+			Reference struct {
+				URI string `xml:"URI,attr"`
+			} `xml:"CipherData>CipherReference"`
+		} `xml:"EncryptedData"`
+	}
+	if err := r.readXml("META-INF/encryption.xml", &encryption); err != nil {
+		return nil, err
+	}
+	for _, data := range encryption.Data {
+		if data.Method.Algorithm != idpfObfuscation {
+			return nil, errors.New("encrypted files are not supported")
+		}
+		result[resolveHref("", data.Reference.URI)] = true
+	}
+	return result, nil
+}
+
+// Quote a CSS string.
+func cssString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\a `).Replace(s) + `"`
+}
+
+// Return a @font-face rule for each font.  `src` returns the URL of a font.
+func fontFaceRules(fonts []epubFont, src func(epubFont) string) string {
+	var b strings.Builder
+	for _, font := range fonts {
+		fmt.Fprintf(&b, "@font-face {font-family:%s;", cssString(font.Family))
+		if font.Weight != "" {
+			fmt.Fprintf(&b, "font-weight:%s;", font.Weight)
+		}
+		if font.Style != "" {
+			fmt.Fprintf(&b, "font-style:%s;", font.Style)
+		}
+		fmt.Fprintf(&b, "src:url(%s);}\n", src(font))
+	}
+	return b.String()
+}
+
+var fontFaceRegexp = regexp.MustCompile(`@font-face \{font-family:"((?:[^"\\]|\\.)*)";(?:font-weight:([^;]*);)?(?:font-style:([^;]*);)?src:url\(([^)]*)\);\}`)
+
+// Return the fonts declared by the `fontFaceRules` in the stylesheet `style`,
+// found at `cssPath`, by the path of the font file.
+func readFontFaces(style, cssPath string) map[string]epubFont {
+	result := map[string]epubFont{}
+	for _, m := range fontFaceRegexp.FindAllStringSubmatch(style, -1) {
+		family := strings.NewReplacer(`\a `, "\n", `\"`, `"`, `\\`, `\`).Replace(m[1])
+		result[resolveHref(cssPath, m[4])] = epubFont{
+			Font: Font{Family: family, Weight: m[2], Style: m[3]},
+			Href: m[4],
+		}
+	}
+	return result
+}
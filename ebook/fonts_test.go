@@ -0,0 +1,51 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/expect"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestFonts(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Fonts = []Font{
+		{Family: "Go", Data: goregular.TTF},
+		{Family: "Go", Weight: "bold", Data: gobold.TTF, Obfuscate: true},
+		{Family: "Bad", Data: []byte("not a font")},
+	}
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	expect.True(t, strings.HasPrefix(entries["book/style.css"],
+		"@font-face {font-family:\"Go\";src:url(fonts/font00.ttf);}\n"+
+			"@font-face {font-family:\"Go\";font-weight:bold;src:url(fonts/font01.ttf);}\n"))
+	expect.True(t, strings.Contains(entries["book/content.opf"],
+		`<item id="font01" href="fonts/font01.ttf" media-type="font/ttf"/>`))
+	expect.True(t, strings.Contains(entries["META-INF/encryption.xml"],
+		`<enc:CipherReference URI="book/fonts/font01.ttf"/>`))
+	expect.True(t, !strings.Contains(entries["META-INF/encryption.xml"], "font00"))
+	expect.Equal(t, string(goregular.TTF), entries["book/fonts/font00.ttf"])
+	stored := []byte(entries["book/fonts/font01.ttf"])
+	expect.True(t, !bytes.Equal(gobold.TTF[:1040], stored[:1040]))
+	expect.True(t, bytes.Equal(gobold.TTF[1040:], stored[1040:]))
+	expect.True(t, bytes.Equal(gobold.TTF, obfuscateFont(stored, book.BookIdentifier())))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.DeepEqual(t, book.Fonts[:2], result.Fonts)
+	expect.Equal(t, "", result.Style)
+}
@@ -7,39 +7,185 @@ import (
 	"bytes"
 	"image"
 	"image/color"
+	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
+	"math"
 
+	_ "golang.org/x/image/bmp"
 	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 )
 
-func saveJpegWithScale(src []byte, minWidth, minHeight int) ([]byte, error) {
-	var decodeReader bytes.Reader
-	decodeReader.Reset(src)
-	img, fmt, err := image.Decode(&decodeReader)
+// How a cover is fitted to the aspect ratio of `CoverOptions`.
+type CoverFit int
+
+const (
+	// Keep the aspect ratio of the image; only scale it.
+	CoverFitScale CoverFit = iota
+	// Scale the image to fill the aspect ratio, cropping what is left over.
+	CoverFitCrop
+	// Scale the image to fit inside the aspect ratio, padding it with a
+	// color sampled from the edges of the image.
+	CoverFitLetterbox
+	// Scale the image to fit inside the aspect ratio, padding it with a
+	// blurred copy of the image.
+	CoverFitBlur
+)
+
+// Defaults for `CoverOptions`.
+const (
+	DefaultCoverWidth     = 400
+	DefaultCoverHeight    = 600
+	DefaultCoverMaxWidth  = 1200
+	DefaultCoverMaxHeight = 1800
+	DefaultCoverQuality   = 80
+)
+
+// Settings for processing the cover of an Epub.  The zero value gives the
+// defaults.
+type CoverOptions struct {
+	// The minimum size of the cover, which also gives the aspect ratio for
+	// CoverFitCrop, CoverFitLetterbox, and CoverFitBlur.  Smaller images are
+	// scaled up.  Zero means DefaultCoverWidth and DefaultCoverHeight.
+	Width, Height int
+	// Larger images are scaled down.  Zero means DefaultCoverMaxWidth and
+	// DefaultCoverMaxHeight; negative means no limit.
+	MaxWidth, MaxHeight int
+	Fit                 CoverFit
+	// The JPEG quality, from 1 to 100, of re-encoded images.  A JPEG that
+	// needs no changes is kept as it is.  Zero means DefaultCoverQuality.
+	Quality int
+}
+
+func (options CoverOptions) withDefaults() CoverOptions {
+	if options.Width <= 0 || options.Height <= 0 {
+		options.Width, options.Height = DefaultCoverWidth, DefaultCoverHeight
+	}
+	if options.MaxWidth == 0 {
+		options.MaxWidth = DefaultCoverMaxWidth
+	}
+	if options.MaxHeight == 0 {
+		options.MaxHeight = DefaultCoverMaxHeight
+	}
+	if options.Quality <= 0 || options.Quality > 100 {
+		options.Quality = DefaultCoverQuality
+	}
+	return options
+}
+
+// Decode a JPEG, PNG, GIF, WebP, or BMP image and return it as a JPEG, sized
+// and fitted according to `options`.
+func processCover(src []byte, options CoverOptions) ([]byte, error) {
+	options = options.withDefaults()
+	img, format, err := image.Decode(bytes.NewReader(src))
 	if err != nil {
 		return nil, err
 	}
-	imgSize := img.Bounds().Size()
-	if fmt == "jpeg" && imgSize.X >= minWidth && imgSize.Y >= minHeight {
-		return src, nil
+	bounds := img.Bounds()
+	imgW, imgH := float64(bounds.Dx()), float64(bounds.Dy())
+	if imgW == 0 || imgH == 0 {
+		return nil, image.ErrFormat
 	}
-	if imgSize.X < minWidth || imgSize.Y < minHeight {
-		scale := float64(minWidth) / float64(imgSize.X)
-		scaleY := float64(minHeight) / float64(imgSize.Y)
-		if scaleY > scale {
-			scale = scaleY
+	aspect := float64(options.Width) / float64(options.Height)
+
+	// The part of the image that is used, and the size of the canvas it is
+	// placed on, before scaling.
+	region := bounds
+	canvasW, canvasH := imgW, imgH
+	switch options.Fit {
+	case CoverFitCrop:
+		if imgW/imgH > aspect {
+			w := int(math.Round(imgH * aspect))
+			region.Min.X += (bounds.Dx() - w) / 2
+			region.Max.X = region.Min.X + w
+		} else {
+			h := int(math.Round(imgW / aspect))
+			region.Min.Y += (bounds.Dy() - h) / 2
+			region.Max.Y = region.Min.Y + h
+		}
+		canvasW, canvasH = float64(region.Dx()), float64(region.Dy())
+	case CoverFitLetterbox, CoverFitBlur:
+		if imgW/imgH > aspect {
+			canvasH = imgW / aspect
+		} else {
+			canvasW = imgH * aspect
 		}
-		dst := image.NewNRGBA(image.Rectangle{
-			Max: image.Point{int(float64(imgSize.X) * scale), int(float64(imgSize.Y) * scale)}})
-		draw.Draw(dst, dst.Bounds(), &image.Uniform{&color.Gray{128}}, image.Point{}, draw.Src)
-		draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
-		img = dst
 	}
+
+	scale := math.Max(1, math.Max(float64(options.Width)/canvasW, float64(options.Height)/canvasH))
+	if options.MaxWidth > 0 {
+		scale = math.Min(scale, float64(options.MaxWidth)/canvasW)
+	}
+	if options.MaxHeight > 0 {
+		scale = math.Min(scale, float64(options.MaxHeight)/canvasH)
+	}
+	dstW := atLeastOne(int(math.Round(canvasW * scale)))
+	dstH := atLeastOne(int(math.Round(canvasH * scale)))
+	if format == "jpeg" && region == bounds && dstW == bounds.Dx() && dstH == bounds.Dy() {
+		return src, nil
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	var background image.Image = &image.Uniform{color.Gray{128}}
+	if options.Fit == CoverFitLetterbox {
+		background = &image.Uniform{edgeColor(img)}
+	}
+	draw.Draw(dst, dst.Bounds(), background, image.Point{}, draw.Src)
+	if options.Fit == CoverFitBlur {
+		drawBlurred(dst, img)
+	}
+	w, h := int(math.Round(float64(region.Dx())*scale)), int(math.Round(float64(region.Dy())*scale))
+	target := image.Rect(0, 0, w, h).Add(image.Point{(dstW - w) / 2, (dstH - h) / 2})
+	scaler := draw.Interpolator(draw.BiLinear)
+	if scale < 1 {
+		scaler = draw.CatmullRom
+	}
+	scaler.Scale(dst, target, img, region, draw.Over, nil)
+
 	var encodeBuffer bytes.Buffer
-	jpegOptions := jpeg.Options{Quality: 80}
-	if err = jpeg.Encode(&encodeBuffer, img, &jpegOptions); err != nil {
+	if err = jpeg.Encode(&encodeBuffer, dst, &jpeg.Options{Quality: options.Quality}); err != nil {
 		return nil, err
 	}
 	return encodeBuffer.Bytes(), nil
 }
+
+// Return the average color of the pixels on the edges of the image.
+func edgeColor(img image.Image) color.Color {
+	b := img.Bounds()
+	var r, g, bl, n uint64
+	add := func(x, y int) {
+		cr, cg, cb, _ := img.At(x, y).RGBA()
+		r, g, bl, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), n+1
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		add(x, b.Min.Y)
+		add(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		add(b.Min.X, y)
+		add(b.Max.X-1, y)
+	}
+	return color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), 0xffff}
+}
+
+// Fill `dst` with a blurred copy of `img`, scaled to cover it and darkened.
+func drawBlurred(dst *image.RGBA, img image.Image) {
+	b := img.Bounds()
+	scale := math.Max(float64(dst.Rect.Dx())/float64(b.Dx()), float64(dst.Rect.Dy())/float64(b.Dy()))
+	// Scaling down to a few pixels and back up blurs the image.
+	small := image.NewRGBA(image.Rect(0, 0,
+		atLeastOne(int(float64(b.Dx())*scale/32)), atLeastOne(int(float64(b.Dy())*scale/32))))
+	draw.CatmullRom.Scale(small, small.Rect, img, b, draw.Src, nil)
+	w, h := int(math.Ceil(float64(b.Dx())*scale)), int(math.Ceil(float64(b.Dy())*scale))
+	target := image.Rect(0, 0, w, h).Add(image.Point{(dst.Rect.Dx() - w) / 2, (dst.Rect.Dy() - h) / 2})
+	draw.BiLinear.Scale(dst, target, small, small.Rect, draw.Over, nil)
+	draw.Draw(dst, dst.Rect, &image.Uniform{color.NRGBA{0, 0, 0, 64}}, image.Point{}, draw.Over)
+}
+
+func atLeastOne(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HalCanary/facility/expect"
+	"golang.org/x/image/bmp"
+)
+
+func makeTestCover(t *testing.T, width, height int, encode func(*bytes.Buffer, image.Image) error) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{200, 0, 0, 255}
+			if x < 10 || y < 10 || x >= width-10 || y >= height-10 {
+				c = color.RGBA{0, 0, 200, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buffer bytes.Buffer
+	if err := encode(&buffer, img); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func encodePng(b *bytes.Buffer, img image.Image) error  { return png.Encode(b, img) }
+func encodeGif(b *bytes.Buffer, img image.Image) error  { return gif.Encode(b, img, nil) }
+func encodeBmp(b *bytes.Buffer, img image.Image) error  { return bmp.Encode(b, img) }
+func encodeJpeg(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }
+
+func decodeCover(t *testing.T, data []byte) image.Image {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestProcessCover(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		src           []byte
+		options       CoverOptions
+		width, height int
+	}{
+		{"scale", makeTestCover(t, 400, 300, encodePng), CoverOptions{}, 800, 600},
+		{"gif", makeTestCover(t, 400, 300, encodeGif), CoverOptions{}, 800, 600},
+		{"bmp", makeTestCover(t, 400, 300, encodeBmp), CoverOptions{}, 800, 600},
+		{"crop", makeTestCover(t, 400, 300, encodePng), CoverOptions{Fit: CoverFitCrop}, 400, 600},
+		{"letterbox", makeTestCover(t, 400, 300, encodePng), CoverOptions{Fit: CoverFitLetterbox}, 400, 600},
+		{"blur", makeTestCover(t, 400, 300, encodePng), CoverOptions{Fit: CoverFitBlur}, 400, 600},
+		{"max", makeTestCover(t, 400, 300, encodePng),
+			CoverOptions{Width: 40, Height: 60, MaxWidth: 100, MaxHeight: 100}, 100, 75},
+		{"square", makeTestCover(t, 100, 100, encodeJpeg),
+			CoverOptions{Width: 300, Height: 300, Fit: CoverFitCrop}, 300, 300},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := processCover(tc.src, tc.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := decodeCover(t, result)
+			expect.Equal(t, tc.width, img.Bounds().Dx())
+			expect.Equal(t, tc.height, img.Bounds().Dy())
+		})
+	}
+
+	src := makeTestCover(t, 400, 300, encodePng)
+	letterbox, _ := processCover(src, CoverOptions{Fit: CoverFitLetterbox})
+	r, g, b, _ := decodeCover(t, letterbox).At(200, 10).RGBA()
+	expect.True(t, b>>8 > 150 && r>>8 < 50 && g>>8 < 50)
+	crop, _ := processCover(src, CoverOptions{Fit: CoverFitCrop})
+	r, _, b, _ = decodeCover(t, crop).At(5, 300).RGBA()
+	expect.True(t, r>>8 > 150 && b>>8 < 50)
+
+	low, _ := processCover(src, CoverOptions{Quality: 10})
+	high, _ := processCover(src, CoverOptions{Quality: 95})
+	expect.True(t, len(low) < len(high))
+
+	jpegSrc := makeTestCover(t, 400, 600, encodeJpeg)
+	same, _ := processCover(jpegSrc, CoverOptions{})
+	expect.True(t, bytes.Equal(jpegSrc, same))
+
+	_, err := processCover([]byte("not an image"), CoverOptions{})
+	expect.True(t, err != nil)
+}
@@ -0,0 +1,383 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// Write the ebook as a CommonMark document, with a YAML front matter block
+// holding the metadata.  Tables and strikethrough use GitHub Flavored
+// Markdown syntax.
+func (info EbookInfo) WriteMarkdown(dst io.Writer) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	yamlField(&b, "title", info.Title)
+	yamlField(&b, "author", info.AuthorNames())
+	if len(info.Contributors) > 0 {
+		b.WriteString("contributors:\n")
+		for _, c := range info.Contributors {
+			fmt.Fprintf(&b, "  - name: %s\n    role: %s\n", strconv.Quote(c.Name), strconv.Quote(c.role()))
+			if c.FileAs != "" {
+				fmt.Fprintf(&b, "    file-as: %s\n", strconv.Quote(c.FileAs))
+			}
+		}
+	}
+	yamlField(&b, "language", info.Language)
+	yamlField(&b, "source", info.Source)
+	yamlField(&b, "identifier", info.BookIdentifier())
+	if !info.Modified.IsZero() {
+		fmt.Fprintf(&b, "modified: %s\n", info.Modified.UTC().Format(time.RFC3339))
+	}
+	if info.Series != "" {
+		yamlField(&b, "series", info.Series)
+		if info.SeriesIndex != 0 {
+			fmt.Fprintf(&b, "series-index: %s\n", formatSeriesIndex(info.SeriesIndex))
+		}
+	}
+	yamlField(&b, "description", info.Comments)
+	b.WriteString("---\n")
+
+	walkToc(makeToc(info.Chapters), func(e *tocEntry) {
+		level := e.Depth + 1
+		b.WriteString("\n" + mdHeading(level, mdEscape(e.Title)) + "\n")
+		if e.Chapter < 0 {
+			return
+		}
+		chapter := info.Chapters[e.Chapter]
+		if chapter.Url != "" {
+			fmt.Fprintf(&b, "\n<%s>\n", chapter.Url)
+		}
+		if !chapter.Modified.IsZero() {
+			fmt.Fprintf(&b, "\n*%s*\n", chapter.Modified.Format("2006-01-02"))
+		}
+		if chapter.Content != nil {
+			c := markdownConverter{headingOffset: level}
+			for _, block := range c.blocks(chapter.Content) {
+				b.WriteString("\n" + block + "\n")
+			}
+		}
+	})
+	_, err := io.WriteString(dst, b.String())
+	return err
+}
+
+// Write a YAML field with a double-quoted string value, if the value is not
+// empty.
+func yamlField(b *strings.Builder, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s: %s\n", key, strconv.Quote(value))
+	}
+}
+
+func mdHeading(level int, text string) string {
+	if level > 6 {
+		level = 6
+	}
+	return strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\\\n", " ")
+}
+
+var (
+	mdEscapeReplacer    = strings.NewReplacer("\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "<", "\\<", ">", "\\>", "&", "\\&", "~", "\\~")
+	mdLineStartRegexp   = regexp.MustCompile(`(?m)^([#+=-]|[0-9]+[.)])`)
+	mdOrderedListRegexp = regexp.MustCompile(`^([0-9]+)\. `)
+	mdBreakSpaceRegexp  = regexp.MustCompile(` *\\\n *`)
+	mdSpacesRegexp      = regexp.MustCompile(`  +`)
+	mdBacktickRegexp    = regexp.MustCompile("`+")
+	whitespaceRegexp    = regexp.MustCompile(`\s+`)
+)
+
+// Escape text so that it is not interpreted as Markdown syntax.
+func mdEscape(s string) string {
+	return mdEscapeReplacer.Replace(s)
+}
+
+// Escape characters that would start a block if they began a line.
+func mdEscapeLineStarts(s string) string {
+	return mdLineStartRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		return m[:len(m)-1] + "\\" + m[len(m)-1:]
+	})
+}
+
+// Return the shortest run of backticks not found in `s`.
+func mdFence(s string, min int) string {
+	length := min
+	for _, run := range mdBacktickRegexp.FindAllString(s, -1) {
+		if len(run) >= length {
+			length = len(run) + 1
+		}
+	}
+	return strings.Repeat("`", length)
+}
+
+// Return the text of all descendants, with whitespace preserved.
+func rawText(node *Node) string {
+	if node.Type == dom.TextNode {
+		return node.Data
+	}
+	var b strings.Builder
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		if isElement(c, "br") {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(rawText(c))
+		}
+	}
+	return b.String()
+}
+
+// Add `prefix` to the first line of `s`, and `indent` to the rest.
+func prefixLines(s, prefix, indent string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		p := indent
+		if i == 0 {
+			p = prefix
+		}
+		if line == "" {
+			p = strings.TrimRight(p, " ")
+		}
+		lines[i] = p + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Converts HTML content to Markdown.
+type markdownConverter struct {
+	headingOffset int // Added to the level of headings in the content.
+	inTable       bool
+}
+
+// Return the Markdown blocks for the children of `node`.
+func (c markdownConverter) blocks(node *Node) []string {
+	var result []string
+	var inline strings.Builder
+	flush := func() {
+		if text := c.finishInline(inline.String()); text != "" {
+			result = append(result, mdEscapeLineStarts(text))
+		}
+		inline.Reset()
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == dom.ElementNode && isBlockElement(child.Data) {
+			flush()
+			result = append(result, c.block(child)...)
+		} else {
+			inline.WriteString(c.inline(child))
+		}
+	}
+	flush()
+	return result
+}
+
+// Collapse the whitespace of inline Markdown.
+func (c markdownConverter) finishInline(s string) string {
+	s = mdSpacesRegexp.ReplaceAllString(s, " ")
+	s = mdBreakSpaceRegexp.ReplaceAllString(s, "\\\n")
+	s = strings.TrimSpace(s)
+	for strings.HasSuffix(s, "\\") {
+		s = strings.TrimSpace(strings.TrimSuffix(s, "\\"))
+	}
+	return s
+}
+
+func isBlockElement(tag string) bool {
+	switch tag {
+	case "address", "article", "aside", "blockquote", "body", "center", "dd", "details",
+		"div", "dl", "dt", "fieldset", "figcaption", "figure", "footer", "form", "h1", "h2",
+		"h3", "h4", "h5", "h6", "header", "hr", "html", "li", "main", "nav", "ol", "p", "pre",
+		"section", "summary", "table", "ul", "script", "style", "head", "title":
+		return true
+	}
+	return false
+}
+
+// Return the Markdown blocks for a block element.
+func (c markdownConverter) block(node *Node) []string {
+	switch node.Data {
+	case "script", "style", "head", "title":
+		return nil
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := c.finishInline(c.inlineChildren(node))
+		if text == "" {
+			return nil
+		}
+		return []string{mdHeading(int(node.Data[1]-'0')+c.headingOffset, text)}
+	case "hr":
+		return []string{"* * *"}
+	case "pre":
+		text := strings.TrimSuffix(strings.TrimPrefix(rawText(node), "\n"), "\n")
+		fence := mdFence(text, 3)
+		return []string{fence + "\n" + text + "\n" + fence}
+	case "blockquote":
+		return []string{prefixLines(strings.Join(c.blocks(node), "\n\n"), "> ", "> ")}
+	case "ul", "ol":
+		return []string{c.list(node)}
+	case "table":
+		if table := c.table(node); table != "" {
+			return []string{table}
+		}
+		return nil
+	}
+	return c.blocks(node)
+}
+
+func (c markdownConverter) list(node *Node) string {
+	number := 1
+	if start, err := strconv.Atoi(dom.GetAttribute(node, "start")); err == nil {
+		number = start
+	}
+	var items []string
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if !isElement(child, "li") {
+			continue
+		}
+		marker := "- "
+		if node.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		separator := "\n"
+		if dom.FindNodeByTag(child, "p") != nil {
+			separator = "\n\n"
+		}
+		var item string
+		for i, block := range c.blocks(child) {
+			if i > 0 {
+				item += separator
+				// Only a list starting at 1 can interrupt a paragraph.
+				if m := mdOrderedListRegexp.FindStringSubmatch(block); separator == "\n" && m != nil && m[1] != "1" {
+					item += "\n"
+				}
+			}
+			item += block
+		}
+		items = append(items, prefixLines(item, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c markdownConverter) table(node *Node) string {
+	c.inTable = true
+	var rows [][]string
+	columns := 0
+	for _, tr := range dom.FindNodesByTagAndAttrib(node, "tr", "", "") {
+		var row []string
+		for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+			if isElement(cell, "td") || isElement(cell, "th") {
+				text := c.finishInline(c.inlineChildren(cell))
+				row = append(row, strings.ReplaceAll(text, "\\\n", " "))
+			}
+		}
+		if len(row) > columns {
+			columns = len(row)
+		}
+		rows = append(rows, row)
+	}
+	if columns == 0 {
+		return ""
+	}
+	var lines []string
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c markdownConverter) inlineChildren(node *Node) string {
+	var b strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+// Return the inline Markdown for a node.
+func (c markdownConverter) inline(node *Node) string {
+	switch node.Type {
+	case dom.TextNode:
+		text := mdEscape(whitespaceRegexp.ReplaceAllString(node.Data, " "))
+		if c.inTable {
+			text = strings.ReplaceAll(text, "|", "\\|")
+		}
+		return text
+	case dom.ElementNode:
+	default:
+		return ""
+	}
+	switch node.Data {
+	case "br":
+		return "\\\n"
+	case "em", "i", "cite", "dfn", "var":
+		return mdWrap("*", c.inlineChildren(node))
+	case "strong", "b":
+		return mdWrap("**", c.inlineChildren(node))
+	case "del", "s", "strike":
+		return mdWrap("~~", c.inlineChildren(node))
+	case "code", "kbd", "samp", "tt":
+		text := whitespaceRegexp.ReplaceAllString(rawText(node), " ")
+		if text == "" {
+			return ""
+		}
+		fence := mdFence(text, 1)
+		if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+			text = " " + text + " "
+		}
+		return fence + text + fence
+	case "a":
+		text := c.finishInline(c.inlineChildren(node))
+		href := dom.GetAttribute(node, "href")
+		if href == "" {
+			return text
+		}
+		if text == "" {
+			if !strings.Contains(href, ":") {
+				return ""
+			}
+			return "<" + href + ">"
+		}
+		return "[" + text + "](" + mdDestination(href) + ")"
+	case "img":
+		src := dom.GetAttribute(node, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + mdEscape(dom.GetAttribute(node, "alt")) + "](" + mdDestination(src) + ")"
+	case "script", "style":
+		return ""
+	}
+	return c.inlineChildren(node)
+}
+
+// Wrap inline Markdown in emphasis markers, keeping surrounding whitespace
+// outside of the markers.
+func mdWrap(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	start := strings.Index(text, trimmed)
+	return text[:start] + marker + trimmed + marker + text[start+len(trimmed):]
+}
+
+// Format a link destination.
+func mdDestination(href string) string {
+	if strings.ContainsAny(href, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(href) + ">"
+	}
+	return href
+}
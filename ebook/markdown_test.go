@@ -0,0 +1,85 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Comments = "Some \"quoted\"\nComments"
+	book.Chapters = book.Chapters[:1]
+	doc, err := dom.Parse(strings.NewReader(`<div>
+<h1>Part <em>One</em></h1>
+<p>Some  <em>emphasis </em>and <strong>strong</strong> text, a <a href="https://example.com/x">link</a>
+and <code>a` + "`" + `b</code>.<br/>
+# not a heading * not a list</p>
+<blockquote><p>Quoted</p><p>twice</p></blockquote>
+<ul><li>one</li><li>two<ol start="3"><li>three</li></ol></li></ul>
+<hr/>
+<pre>  x := 1
+  y := 2</pre>
+<table><tr><th>A</th><th>B|C</th></tr><tr><td>1</td></tr></table>
+<p><img src="pic.png" alt="a [pic]"/> <del>gone</del></p>
+</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Chapters[0].Content = dom.FindNodeByTag(doc, "div")
+	var buffer bytes.Buffer
+	if err := book.WriteMarkdown(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, `---
+title: "the Title"
+author: "The Author"
+language: "en"
+source: "https://example.com/"
+identifier: "urn:uuid:dd2c1780-811a-5296-81c5-178a0ef488bc"
+modified: 2022-10-01T12:30:00Z
+description: "Some \"quoted\"\nComments"
+---
+
+# One
+
+<https://example.com/one>
+
+*2022-10-01*
+
+## Part *One*
+
+Some *emphasis* and **strong** text, a [link](https://example.com/x) and `+"``a`b``"+`.\
+\# not a heading \* not a list
+
+> Quoted
+>
+> twice
+
+- one
+- two
+
+  3. three
+
+* * *
+
+`+"```"+`
+  x := 1
+  y := 2
+`+"```"+`
+
+| A | B\|C |
+| --- | --- |
+| 1 |  |
+
+![a \[pic\]](pic.png) ~~gone~~
+`, buffer.String())
+	expect.True(t, !strings.Contains(buffer.String(), "\n\n\n"))
+}
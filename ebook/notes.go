@@ -0,0 +1,328 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+var (
+	noteMarkerRegexp  = regexp.MustCompile(`^[\[(]?([0-9]{1,3}|[a-z*†‡§¶]|[ivx]{1,4})[\])]?$`)
+	noteClassRegexp   = regexp.MustCompile(`(?i)\b(fn|fnref|footnote|footnote-ref|noteref|note-ref)`)
+	backlinkRegexp    = regexp.MustCompile(`^\s*([↩↑^]|back|return)\s*$`)
+	noteRoleRegexp    = regexp.MustCompile(`\b(doc-)?(footnote|endnote|rearnote)\b`)
+	notesHeaderRegexp = regexp.MustCompile(`(?i)^\s*((foot|end)?notes?|references)\s*:?\s*$`)
+)
+
+// Elements that can hold the text of a note.  Sections never do: they hold
+// the text of the chapter.
+var noteBlockElements = map[string]bool{
+	"aside": true, "blockquote": true, "dd": true, "div": true, "li": true, "p": true,
+}
+
+// The longest text, in bytes, of a note not marked as one.
+const maxUnmarkedNoteSize = 1000
+
+// A note found in chapter content.
+type contentNote struct {
+	Id   string
+	Body *Node   // The block holding the text of the note.
+	Refs []*Node // The links that refer to the note.
+}
+
+// Find footnotes in chapter content, such as `<sup><a href="#fn1">1</a></sup>`
+// links to `<li id="fn1">` items, and convert them into EPUB3 notes: the
+// links get `epub:type="noteref"` and the notes become `aside` elements.
+// Footnotes are placed after the block that first refers to them; endnotes
+// are collected in a section at the end of the content.  `chapterUrl` is the
+// URL that links within the chapter were resolved against.
+func convertNotes(content *Node, chapterUrl string, endnotes bool) *Node {
+	if content == nil {
+		return content
+	}
+	chapterUrl, _, _ = strings.Cut(chapterUrl, "#")
+	order := map[*Node]int{}
+	targets := map[string]*Node{}
+	var links []*Node
+	for i, node := range dom.FindNodesByTagAndAttrib(content, "", "", "") {
+		order[node] = i
+		id := dom.GetAttribute(node, "id")
+		if id == "" && node.Data == "a" {
+			id = dom.GetAttribute(node, "name")
+		}
+		if _, ok := targets[id]; id != "" && !ok {
+			targets[id] = node
+		}
+		if node.Data == "a" {
+			links = append(links, node)
+		}
+	}
+
+	var notes []*contentNote
+	byId := map[string]*contentNote{}
+	for _, link := range links {
+		id := localFragment(dom.GetAttribute(link, "href"), chapterUrl)
+		target := targets[id]
+		if target == nil || order[target] < order[link] || !isNoteRef(link) {
+			continue
+		}
+		note := byId[id]
+		if note == nil {
+			body := noteBody(target, content)
+			if body == nil || isInside(link, body) || !isNoteBody(body) {
+				continue
+			}
+			note = &contentNote{Id: id, Body: body}
+			byId[id] = note
+			notes = append(notes, note)
+		}
+		note.Refs = append(note.Refs, link)
+	}
+	// A block holding the text of several notes cannot be converted.
+	bodies := map[*Node]int{}
+	for _, note := range notes {
+		bodies[note.Body]++
+	}
+
+	kind := "footnote"
+	var section *Node
+	if endnotes {
+		kind = "endnote"
+	}
+	for _, note := range notes {
+		if bodies[note.Body] > 1 || note.Body.Parent == nil {
+			continue
+		}
+		for _, ref := range note.Refs {
+			setAttribute(ref, "href", "#"+note.Id)
+			setAttribute(ref, "epub:type", "noteref")
+		}
+		parent := note.Body.Parent
+		rule := prevElement(note.Body.PrevSibling)
+		aside := makeNoteAside(note, kind)
+		if isElement(rule, "hr") && nextElement(rule.NextSibling) == nil {
+			// The rule separated the notes from the text.
+			dom.Remove(rule)
+		}
+		removeEmptyNoteContainers(parent, content)
+		if endnotes {
+			if section == nil {
+				section = dom.Element("section", dom.Attr{"epub:type": "endnotes"})
+			}
+			dom.Append(section, aside)
+		} else {
+			block := note.Refs[0]
+			for block.Parent != nil && block.Parent != content {
+				block = block.Parent
+			}
+			if block.Parent == content {
+				content.InsertBefore(aside, block.NextSibling)
+			} else {
+				dom.Append(content, aside)
+			}
+		}
+	}
+	if section != nil {
+		dom.Append(content, dom.Elem("hr"), section)
+	}
+	return content
+}
+
+// Return the fragment of `href`, if it links within the chapter.
+func localFragment(href, chapterUrl string) string {
+	base, fragment, found := strings.Cut(href, "#")
+	if !found || (base != "" && base != chapterUrl) {
+		return ""
+	}
+	return fragment
+}
+
+// Return true if the link looks like a reference to a note.
+func isNoteRef(link *Node) bool {
+	for node, depth := link, 0; node != nil && depth < 3; node, depth = node.Parent, depth+1 {
+		if isElement(node, "sup") {
+			return true
+		}
+	}
+	if dom.FindNodeByTag(link, "sup") != nil {
+		return true
+	}
+	if noteClassRegexp.MatchString(dom.GetAttribute(link, "class")) ||
+		noteClassRegexp.MatchString(dom.GetAttribute(link, "id")) ||
+		strings.Contains(dom.GetAttribute(link, "role"), "noteref") {
+		return true
+	}
+	return noteMarkerRegexp.MatchString(strings.TrimSpace(dom.ExtractText(link)))
+}
+
+// Return the block that holds the text of the note whose target is `target`.
+func noteBody(target, content *Node) *Node {
+	for node := target; node != nil && node != content; node = node.Parent {
+		if node.Type == dom.ElementNode && noteBlockElements[node.Data] {
+			return node
+		}
+		if node.Type == dom.ElementNode && (isBlockElement(node.Data) || node.Data == "td") {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Return true if the block looks like the text of a note: an item of an
+// ordered list, a short paragraph or aside, or a block marked as a note.
+// Blocks holding headings are parts of the chapter, not notes.
+func isNoteBody(body *Node) bool {
+	for _, heading := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+		if dom.FindNodeByTag(body, heading) != nil {
+			return false
+		}
+	}
+	switch {
+	case noteClassRegexp.MatchString(dom.GetAttribute(body, "class")),
+		noteRoleRegexp.MatchString(dom.GetAttribute(body, "role")),
+		noteRoleRegexp.MatchString(getEpubType(body)):
+		return true
+	case body.Data == "li":
+		return isElement(body.Parent, "ol")
+	case body.Data == "p" || body.Data == "aside":
+		return len(dom.ExtractText(body)) <= maxUnmarkedNoteSize
+	}
+	return false
+}
+
+// Return the `epub:type` of the element.
+func getEpubType(node *Node) string {
+	for _, attr := range node.Attr {
+		if attr.Namespace == "epub" && attr.Key == "type" {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func isInside(node, ancestor *Node) bool {
+	for ; node != nil; node = node.Parent {
+		if node == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func setAttribute(node *Node, key, value string) {
+	if attr := getNodeAttribute(node, key); attr != nil {
+		attr.Val = value
+	} else {
+		dom.AddAttribute(node, key, value)
+	}
+}
+
+// Remove the space that separated a backlink at the end of its parent from
+// the text of the note.
+func trimBeforeBacklink(a *Node) {
+	prev, next := a.PrevSibling, a.NextSibling
+	if prev == nil || prev.Type != dom.TextNode {
+		return
+	}
+	if next != nil && (next.Type != dom.TextNode || strings.TrimSpace(next.Data) != "") {
+		return
+	}
+	prev.Data = strings.TrimRightFunc(prev.Data, unicode.IsSpace)
+}
+
+// Move the text of a note into an aside, removing links back to the
+// references.
+func makeNoteAside(note *contentNote, kind string) *Node {
+	body := note.Body
+	dom.Remove(body)
+	refIds := map[string]bool{}
+	for _, ref := range note.Refs {
+		for node := ref; node != nil && !isElement(node, "p"); node = node.Parent {
+			if id := dom.GetAttribute(node, "id"); id != "" {
+				refIds[id] = true
+			}
+		}
+	}
+	for _, a := range dom.FindNodesByTagAndAttrib(body, "a", "", "") {
+		href := dom.GetAttribute(a, "href")
+		_, fragment, _ := strings.Cut(href, "#")
+		if refIds[fragment] || (href != "" && backlinkRegexp.MatchString(dom.ExtractText(a))) {
+			trimBeforeBacklink(a)
+			dom.Remove(a)
+		} else if dom.GetAttribute(a, "name") == note.Id || dom.GetAttribute(a, "id") == note.Id {
+			// An anchor that marked the note.
+			for child := a.FirstChild; child != nil; child = a.FirstChild {
+				a.RemoveChild(child)
+				a.Parent.InsertBefore(child, a)
+			}
+			dom.Remove(a)
+		}
+	}
+	for _, node := range dom.FindNodesByTagAndAttrib(body, "", "id", note.Id) {
+		removeAttribute(node, "id")
+	}
+	removeAttribute(body, "id")
+
+	aside := dom.Element("aside", dom.Attr{"epub:type": kind, "id": note.Id})
+	if isElement(body, "p") {
+		return dom.Append(aside, body)
+	}
+	var inline []*Node
+	flush := func() {
+		if len(inline) > 0 {
+			p := dom.Elem("p", inline...)
+			if !isWhitespaceOnly(p) {
+				dom.Append(aside, p)
+			}
+			inline = nil
+		}
+	}
+	for child := body.FirstChild; child != nil; child = body.FirstChild {
+		body.RemoveChild(child)
+		if child.Type == dom.ElementNode && isBlockElement(child.Data) {
+			flush()
+			dom.Append(aside, child)
+		} else {
+			inline = append(inline, child)
+		}
+	}
+	flush()
+	return aside
+}
+
+func removeAttribute(node *Node, key string) {
+	if i := getNodeAttributeIndex(node, key); i >= 0 {
+		node.Attr = append(node.Attr[:i], node.Attr[i+1:]...)
+	}
+}
+
+// Remove `node` and its ancestors below `content` while they hold nothing but
+// whitespace, rules, and headings such as "Notes".
+func removeEmptyNoteContainers(node, content *Node) {
+	for node != nil && node != content && node.Parent != nil && isEmptyNoteContainer(node) {
+		parent := node.Parent
+		if prev := prevElement(node.PrevSibling); isElement(prev, "hr") && nextElement(node.NextSibling) == nil {
+			dom.Remove(prev)
+		}
+		dom.Remove(node)
+		node = parent
+	}
+}
+
+func isEmptyNoteContainer(node *Node) bool {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case isWhitespaceOnly(child) && (child.Type == dom.TextNode || child.FirstChild == nil && !isElement(child, "img")):
+		case isElement(child, "hr"):
+		case child.Type == dom.ElementNode && notesHeaderRegexp.MatchString(dom.ExtractText(child)):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func parseContent(t *testing.T, source string) *Node {
+	t.Helper()
+	doc, err := dom.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dom.FindNodeByTag(doc, "div")
+}
+
+func renderContent(node *Node) string {
+	var buffer bytes.Buffer
+	dom.RenderXHTMLDoc(node, &buffer)
+	return strings.TrimSpace(strings.TrimPrefix(buffer.String(), `<?xml version="1.0" encoding="UTF-8"?>`))
+}
+
+func TestConvertNotes(t *testing.T) {
+	// Pandoc-style notes, with links resolved against the chapter URL.
+	content := parseContent(t, `<div><p>One<a href="https://example.com/one#fn1" id="fnref1"><sup>1</sup></a>.</p>`+
+		`<p>Two<sup><a href="#fn2" id="fnref2">2</a></sup>.</p>`+
+		`<section class="footnotes"><hr/><ol>`+
+		`<li id="fn1"><p>Note one. <a href="#fnref1">↩</a></p></li>`+
+		`<li id="fn2">Note <em>two</em>. <a href="#fnref2">↩</a></li>`+
+		`</ol></section></div>`)
+	expect.Equal(t, `<div><p>One<a href="#fn1" id="fnref1" epub:type="noteref"><sup>1</sup></a>.</p>`+
+		`<aside epub:type="footnote" id="fn1"><p>Note one.</p></aside>`+
+		`<p>Two<sup><a href="#fn2" id="fnref2" epub:type="noteref">2</a></sup>.</p>`+
+		`<aside epub:type="footnote" id="fn2"><p>Note <em>two</em>.</p></aside></div>`,
+		renderContent(convertNotes(content, "https://example.com/one", false)))
+
+	// A backlink directly in the list item.
+	content = parseContent(t, `<div><p>A<sup><a href="#fn1" id="r1">1</a></sup>.</p>`+
+		`<ol><li id="fn1">The note. <a href="#r1">↩</a></li></ol></div>`)
+	expect.Equal(t, `<div><p>A<sup><a href="#fn1" id="r1" epub:type="noteref">1</a></sup>.</p>`+
+		`<aside epub:type="footnote" id="fn1"><p>The note.</p></aside></div>`,
+		renderContent(convertNotes(content, "", false)))
+
+	// Anchors within paragraphs, collected as endnotes.
+	content = parseContent(t, `<div><p>Text<a href="#n1">[1]</a> and <a href="#top">top</a>.</p><hr/>`+
+		`<p><a name="n1"></a>[1] The note.</p></div>`)
+	expect.Equal(t, `<div><p>Text<a href="#n1" epub:type="noteref">[1]</a> and <a href="#top">top</a>.</p>`+
+		`<hr/><section epub:type="endnotes"><aside epub:type="endnote" id="n1"><p>[1] The note.</p></aside></section></div>`,
+		renderContent(convertNotes(content, "", true)))
+
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Chapters[0].Content = parseContent(t, `<div><p>A<sup><a href="#fn1">1</a></sup>.</p><ol><li id="fn1">Note.</li></ol></div>`)
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	chapter := readZipEntries(t, buffer.Bytes())["book/0000.xhtml"]
+	expect.True(t, strings.Contains(chapter, `xmlns:epub="http://www.idpf.org/2007/ops"`))
+	expect.True(t, strings.Contains(chapter, `<aside epub:type="footnote" id="fn1"><p>Note.</p></aside>`))
+}
+
+func TestConvertNotesSkipsSections(t *testing.T) {
+	source := `<div><p>See <a href="#part2">2</a> and <a href="#n1">1</a>.</p>` +
+		`<section id="part2"><h2>Part Two</h2><p>Lots of story.</p></section>` +
+		`<div id="n1"><h3>Not a note</h3></div></div>`
+	content := parseContent(t, source)
+	expected := renderContent(content)
+	expect.Equal(t, expected, renderContent(convertNotes(content, "", false)))
+
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Chapters[0].Content = parseContent(t, `<div><p>A<sup><a href="#fn1">1</a></sup>.</p><ol><li id="fn1">Note.</li></ol></div>`)
+	var buffer bytes.Buffer
+	if err := book.WriteEpub(&buffer, EpubOptions{KeepNotes: true}); err != nil {
+		t.Fatal(err)
+	}
+	chapter := readZipEntries(t, buffer.Bytes())["book/0000.xhtml"]
+	expect.True(t, strings.Contains(chapter, `<li id="fn1">Note.</li>`))
+	expect.True(t, !strings.Contains(chapter, `noteref`))
+}
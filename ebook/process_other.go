@@ -0,0 +1,15 @@
+//go:build !unix
+
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Kill a started command.  The processes it started are not killed.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
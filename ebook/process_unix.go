@@ -0,0 +1,22 @@
+//go:build unix
+
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run the command in a process group of its own, so that `killProcessGroup`
+// also kills the processes it starts.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kill a started command and every process in its group.
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,580 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+const (
+	dcNamespace   = "http://purl.org/dc/elements/1.1/"
+	epubTimestamp = "2006-01-02T15:04:05Z"
+)
+
+var descriptionSuffixRegexp = regexp.MustCompile("(?s)^(.*)\n\nSOURCE: .*\nCHAPTERS: [0-9]+\n?$")
+
+type opfDocument struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Elements []opfElement `xml:",any"`
+	} `xml:"metadata"`
+	ManifestItems []xmlItem           `xml:"manifest>item"`
+	Spine         xmlSpine            `xml:"spine"`
+	GuideRefs     []xmlGuideReference `xml:"guide>reference"`
+}
+
+type opfElement struct {
+	XMLName    xml.Name
+	Value      string     `xml:",chardata"`
+	Attributes []xml.Attr `xml:",any,attr"`
+}
+
+func xmlAttribute(attributes []xml.Attr, key string) string {
+	for _, attr := range attributes {
+		if attr.Name.Local == key {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func hasProperty(attributes []xml.Attr, property string) bool {
+	for _, p := range strings.Fields(xmlAttribute(attributes, "properties")) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+type epubReader struct {
+	files map[string]*zip.File
+}
+
+func (r epubReader) read(name string) ([]byte, error) {
+	f := r.files[name]
+	if f == nil {
+		return nil, fmt.Errorf("missing file: %q", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (r epubReader) readXml(name string, v any) error {
+	data, err := r.read(name)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+func (r epubReader) readXhtml(name string) (*Node, error) {
+	f := r.files[name]
+	if f == nil {
+		return nil, fmt.Errorf("missing file: %q", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return dom.ParseXHTML(rc)
+}
+
+// Resolve `href`, relative to the file `base`, into a path within the archive.
+// Fragments are discarded.
+func resolveHref(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	if href == "" {
+		return base
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+// Return the path of the package document named by `META-INF/container.xml`.
+func (r epubReader) rootfile() (string, error) {
+	var container struct {
+		Rootfiles []struct {
+			FullPath  string `xml:"full-path,attr"`
+			MediaType string `xml:"media-type,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := r.readXml("META-INF/container.xml", &container); err != nil {
+		return "", err
+	}
+	for _, rootfile := range container.Rootfiles {
+		if rootfile.MediaType == "application/oebps-package+xml" {
+			return rootfile.FullPath, nil
+		}
+	}
+	return "", errors.New("container.xml: no rootfile")
+}
+
+// Read an Epub, such as one produced by `EbookInfo.Write`.  The table of
+// contents, front matter, and cover page are not returned as chapters.
+func ReadEpub(src io.ReaderAt, size int64) (EbookInfo, error) {
+	var info EbookInfo
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return info, err
+	}
+	r := epubReader{files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		r.files[f.Name] = f
+	}
+	opfPath, err := r.rootfile()
+	if err != nil {
+		return info, err
+	}
+	var opf opfDocument
+	if err = r.readXml(opfPath, &opf); err != nil {
+		return info, err
+	}
+
+	var coverId, seriesId string
+	var contributorIds []string
+	var refinements []opfElement
+	for _, elem := range opf.Metadata.Elements {
+		value := strings.TrimSpace(elem.Value)
+		if elem.XMLName.Space == dcNamespace {
+			switch elem.XMLName.Local {
+			case "identifier":
+				if xmlAttribute(elem.Attributes, "id") == opf.UniqueIdentifier {
+					info.Identifier = value
+				}
+			case "title":
+				if info.Title == "" {
+					info.Title = value
+				}
+			case "creator", "contributor":
+				role := RoleAuthor
+				if elem.XMLName.Local == "contributor" {
+					role = ""
+				}
+				if opfRole := xmlAttribute(elem.Attributes, "role"); opfRole != "" {
+					role = opfRole // OPF 2.0
+				}
+				contributorIds = append(contributorIds, xmlAttribute(elem.Attributes, "id"))
+				info.Contributors = append(info.Contributors, Contributor{
+					Name: value, Role: role, FileAs: xmlAttribute(elem.Attributes, "file-as")})
+			case "language":
+				if info.Language == "" {
+					info.Language = value
+				}
+			case "date":
+				if xmlAttribute(elem.Attributes, "event") == "modification" && info.Modified.IsZero() {
+					info.Modified, _ = time.Parse(epubTimestamp, value) // OPF 2.0
+				}
+			case "source":
+				if info.Source == "" {
+					info.Source = value
+				}
+			case "description":
+				if info.Comments == "" {
+					info.Comments = elem.Value
+					if m := descriptionSuffixRegexp.FindStringSubmatch(elem.Value); m != nil {
+						info.Comments = m[1]
+					}
+				}
+			}
+		} else if elem.XMLName.Local == "meta" {
+			property, refines := xmlAttribute(elem.Attributes, "property"), xmlAttribute(elem.Attributes, "refines")
+			switch {
+			case (property == "role" || property == "file-as") && strings.HasPrefix(refines, "#"):
+				refinements = append(refinements, elem)
+			case property == "dcterms:modified":
+				info.Modified, _ = time.Parse(epubTimestamp, value)
+			case property == "belongs-to-collection" && info.Series == "":
+				info.Series = value
+				seriesId = xmlAttribute(elem.Attributes, "id")
+			case property == "group-position" && refines != "" && refines == "#"+seriesId:
+				info.SeriesIndex, _ = strconv.ParseFloat(value, 64)
+			case xmlAttribute(elem.Attributes, "name") == "calibre:series" && info.Series == "":
+				info.Series = xmlAttribute(elem.Attributes, "content")
+			case xmlAttribute(elem.Attributes, "name") == "calibre:series_index" && info.SeriesIndex == 0:
+				info.SeriesIndex, _ = strconv.ParseFloat(xmlAttribute(elem.Attributes, "content"), 64)
+			case xmlAttribute(elem.Attributes, "name") == "cover":
+				coverId = xmlAttribute(elem.Attributes, "content")
+			}
+		}
+	}
+
+	for _, elem := range refinements {
+		for i, id := range contributorIds {
+			if id != "" && "#"+id == xmlAttribute(elem.Attributes, "refines") {
+				if xmlAttribute(elem.Attributes, "property") == "role" {
+					info.Contributors[i].Role = strings.TrimSpace(elem.Value)
+				} else {
+					info.Contributors[i].FileAs = strings.TrimSpace(elem.Value)
+				}
+			}
+		}
+	}
+	for i, c := range info.Contributors {
+		if c.Role == "" {
+			info.Contributors[i].Role = RoleContributor
+		}
+	}
+	info.Authors = info.AuthorNames()
+
+	items := make(map[string]xmlItem, len(opf.ManifestItems))
+	var navPath string
+	for _, item := range opf.ManifestItems {
+		items[item.Id] = item
+		if hasProperty(item.Attributes, "cover-image") {
+			coverId = item.Id
+		}
+		if hasProperty(item.Attributes, "nav") {
+			navPath = resolveHref(opfPath, item.Href)
+		}
+	}
+	obfuscated, err := r.obfuscatedFiles()
+	if err != nil {
+		return info, err
+	}
+	for _, item := range opf.ManifestItems {
+		if item.MediaType == "text/css" && item.Id == "style" {
+			cssPath := resolveHref(opfPath, item.Href)
+			data, err := r.read(cssPath)
+			if err != nil {
+				return info, err
+			}
+			style := string(data)
+			faces := readFontFaces(style, cssPath)
+			var fonts []epubFont
+			for _, fontItem := range opf.ManifestItems {
+				if !isFontMediaType(fontItem.MediaType) {
+					continue
+				}
+				name := resolveHref(opfPath, fontItem.Href)
+				font := faces[name]
+				if font.Data, err = r.read(name); err != nil {
+					return info, err
+				}
+				if font.Obfuscate = obfuscated[name]; font.Obfuscate {
+					font.Data = obfuscateFont(font.Data, info.Identifier)
+				}
+				info.Fonts = append(info.Fonts, font.Font)
+				fonts = append(fonts, font)
+			}
+			style = strings.TrimPrefix(style, fontFaceRules(fonts, func(f epubFont) string { return f.Href }))
+			if style != DefaultStyle {
+				info.Style = style
+			}
+		}
+	}
+	if item, ok := items[coverId]; ok {
+		if info.Cover, err = r.read(resolveHref(opfPath, item.Href)); err != nil {
+			return info, err
+		}
+	}
+
+	skip := map[string]bool{navPath: true}
+	for _, ref := range opf.GuideRefs {
+		switch ref.Type {
+		case "cover", "toc":
+			skip[resolveHref(opfPath, ref.Href)] = true
+		}
+	}
+
+	labels := map[string]tocLabel{}
+	if navPath != "" {
+		labels, err = r.readNavLabels(navPath)
+	} else if item, ok := items[opf.Spine.Toc]; ok {
+		labels, err = r.readNcxLabels(resolveHref(opfPath, item.Href))
+	}
+	if err != nil {
+		return info, err
+	}
+
+	var partNames []map[string]bool // The file names of the parts of each chapter.
+	for _, itemref := range opf.Spine.Itemrefs {
+		item, ok := items[itemref.Idref]
+		if !ok {
+			return info, fmt.Errorf("spine: unknown idref %q", itemref.Idref)
+		}
+		name := resolveHref(opfPath, item.Href)
+		if skip[name] {
+			continue
+		}
+		doc, err := r.readXhtml(name)
+		if err != nil {
+			return info, fmt.Errorf("%s: %w", name, err)
+		}
+		if isSectionPage(doc) {
+			continue
+		}
+		if content := readChapterPart(doc); content != nil && len(info.Chapters) > 0 {
+			if err = r.inlineImages(content, name); err != nil {
+				return info, err
+			}
+			appendChapterPart(&info.Chapters[len(info.Chapters)-1], content)
+			partNames[len(partNames)-1][path.Base(name)] = true
+			continue
+		}
+		chapter := readChapter(doc, r.files[name].Modified)
+		if chapter.Title == "" {
+			chapter.Title = labels[name].Title
+		}
+		chapter.Sections = labels[name].Sections
+		if err = r.inlineImages(chapter.Content, name); err != nil {
+			return info, err
+		}
+		info.Chapters = append(info.Chapters, chapter)
+		partNames = append(partNames, map[string]bool{path.Base(name): true})
+	}
+	for i, names := range partNames {
+		if len(names) > 1 {
+			unfixPartLinks(info.Chapters[i].Content, names)
+		}
+	}
+	return info, nil
+}
+
+// Replace references to images stored in the archive with data URLs, so that
+// the content no longer depends on the archive.
+func (r epubReader) inlineImages(content *Node, base string) error {
+	for _, img := range dom.FindNodesByTagAndAttrib(content, "img", "", "") {
+		if src := getNodeAttribute(img, "src"); src != nil && !strings.Contains(src.Val, ":") {
+			if name := resolveHref(base, src.Val); r.files[name] != nil {
+				data, err := r.read(name)
+				if err != nil {
+					return err
+				}
+				src.Val = dataUrl(data)
+			}
+		}
+	}
+	return nil
+}
+
+// A table of contents entry, as read from an Epub.
+type tocLabel struct {
+	Title    string
+	Sections []string // Titles of the enclosing entries, outermost first.
+}
+
+func addTocLabel(labels map[string]tocLabel, name, title string, sections []string) {
+	if _, ok := labels[name]; !ok {
+		labels[name] = tocLabel{Title: strings.TrimSpace(title), Sections: append([]string(nil), sections...)}
+	}
+}
+
+func (r epubReader) readNcxLabels(ncxPath string) (map[string]tocLabel, error) {
+	var ncx ncxXml
+	if err := r.readXml(ncxPath, &ncx); err != nil {
+		return nil, err
+	}
+	labels := map[string]tocLabel{}
+	var walk func(navPoints []navPointXml, sections []string)
+	walk = func(navPoints []navPointXml, sections []string) {
+		for _, navPoint := range navPoints {
+			addTocLabel(labels, resolveHref(ncxPath, navPoint.Content.Src), navPoint.Label, sections)
+			walk(navPoint.NavPoints, append(sections, strings.TrimSpace(navPoint.Label)))
+		}
+	}
+	walk(ncx.NavPoints, nil)
+	return labels, nil
+}
+
+func (r epubReader) readNavLabels(navPath string) (map[string]tocLabel, error) {
+	doc, err := r.readXhtml(navPath)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]tocLabel{}
+	var walk func(list *Node, sections []string)
+	walk = func(list *Node, sections []string) {
+		for item := list.FirstChild; item != nil; item = item.NextSibling {
+			if !isElement(item, "li") {
+				continue
+			}
+			var title string
+			for c := item.FirstChild; c != nil; c = c.NextSibling {
+				if isElement(c, "a") {
+					title = strings.TrimSpace(dom.ExtractText(c))
+					addTocLabel(labels, resolveHref(navPath, dom.GetAttribute(c, "href")), title, sections)
+				} else if isElement(c, "span") {
+					title = strings.TrimSpace(dom.ExtractText(c))
+				} else if isElement(c, "ol") {
+					walk(c, append(sections, title))
+				}
+			}
+		}
+	}
+	for _, nav := range dom.FindNodesByTagAndAttrib(doc, "nav", "type", "toc") {
+		if list := dom.FindNodeByTag(nav, "ol"); list != nil {
+			walk(list, nil)
+		}
+	}
+	return labels, nil
+}
+
+func isElement(node *Node, tag string) bool {
+	return node != nil && node.Type == dom.ElementNode && node.Data == tag
+}
+
+func nextElement(node *Node) *Node {
+	for ; node != nil; node = node.NextSibling {
+		if node.Type == dom.ElementNode {
+			return node
+		}
+	}
+	return nil
+}
+
+func prevElement(node *Node) *Node {
+	for ; node != nil; node = node.PrevSibling {
+		if node.Type == dom.ElementNode {
+			return node
+		}
+	}
+	return nil
+}
+
+// Move the nodes in [first, last] out of their parent.  If that is more than one
+// element, wrap them in a div.
+func detachContent(first, last *Node) *Node {
+	var nodes []*Node
+	for node := first; node != nil; node = node.NextSibling {
+		nodes = append(nodes, node)
+		if node == last {
+			break
+		}
+	}
+	var elements []*Node
+	for _, node := range nodes {
+		dom.Remove(node)
+		if node.Type == dom.ElementNode {
+			elements = append(elements, node)
+		}
+	}
+	if len(elements) == 1 && len(nodes) == 1 {
+		return elements[0]
+	}
+	return dom.Elem("div", nodes...)
+}
+
+// Convert a parsed chapter file back into a Chapter.  Files written by
+// `writeChapter` are recognized; other files become a chapter containing the
+// entire body.
+func readChapter(doc *Node, modified time.Time) Chapter {
+	var chapter Chapter
+	body := dom.FindNodeByTag(doc, "body")
+	if body == nil {
+		return chapter
+	}
+	heading := nextElement(body.FirstChild)
+	for node := body.FirstChild; node != nil && node != heading; node = node.NextSibling {
+		if node.Type == dom.CommentNode {
+			chapter.Url = strings.TrimSpace(node.Data)
+			break
+		}
+	}
+	if !isElement(heading, "h2") || dom.GetAttribute(heading, "class") != "chapter" {
+		chapter.Title = strings.TrimSpace(dom.ExtractText(dom.FindNodeByTag(doc, "title")))
+		if body.FirstChild != nil {
+			chapter.Content = detachContent(body.FirstChild, body.LastChild)
+		}
+		return chapter
+	}
+	chapter.Title = strings.TrimSpace(dom.ExtractText(heading))
+	last := heading // The last node before the content.
+	next := nextElement(heading.NextSibling)
+	if isElement(next, "p") && isElement(nextElement(next.FirstChild), "em") {
+		if date, err := time.Parse("2006-01-02", strings.TrimSpace(dom.ExtractText(next))); err == nil {
+			chapter.Modified = date
+			// The archive timestamp is more precise than the printed date.
+			if d := modified.Sub(date); d > -48*time.Hour && d < 48*time.Hour {
+				chapter.Modified = modified
+			}
+			last = next
+		}
+		next = nextElement(next.NextSibling)
+	}
+	// Files not written by `writeChapter` may have no rule before the content.
+	var start *Node
+	if isElement(next, "hr") {
+		last, start = next, next
+	}
+	end := chapterContentEnd(body, start)
+	for node := last.NextSibling; node != nil; node = node.NextSibling {
+		if node == end {
+			chapter.Content = detachContent(last.NextSibling, end)
+			break
+		}
+	}
+	return chapter
+}
+
+// Return the last node of the content of a chapter file, before the closing
+// rule and link written by `writeChapter`.  `start` is the rule before the
+// content, if any.
+func chapterContentEnd(body, start *Node) *Node {
+	end := body.LastChild
+	if last := prevElement(end); isElement(last, "hr") && last != start {
+		end = last.PrevSibling
+		if link := prevElement(end); isElement(link, "div") && isElement(nextElement(link.FirstChild), "a") {
+			if hr := prevElement(link.PrevSibling); isElement(hr, "hr") && hr != start {
+				end = hr.PrevSibling
+			}
+		}
+	}
+	return end
+}
+
+// Return the content of a file written by `writeChapter` for a part of a
+// chapter other than the first, or nil if it is not one.
+func readChapterPart(doc *Node) *Node {
+	body := dom.FindNodeByTag(doc, "body")
+	if body == nil || body.FirstChild == nil {
+		return nil
+	}
+	continued := false
+	for _, class := range strings.Fields(dom.GetAttribute(body, "class")) {
+		continued = continued || class == "continued"
+	}
+	if !continued {
+		return nil
+	}
+	end := chapterContentEnd(body, nil)
+	if end == nil {
+		return nil
+	}
+	return detachContent(body.FirstChild, end)
+}
+
+// Move the content of a later part of a chapter into the chapter.
+func appendChapterPart(chapter *Chapter, content *Node) {
+	if chapter.Content == nil {
+		chapter.Content = content
+	} else if chapter.Content.Type == content.Type && chapter.Content.Data == content.Data {
+		for child := content.FirstChild; child != nil; child = content.FirstChild {
+			content.RemoveChild(child)
+			chapter.Content.AppendChild(child)
+		}
+	} else {
+		dom.Append(chapter.Content, content)
+	}
+}
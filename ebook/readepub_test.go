@@ -0,0 +1,81 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func makeTestBook(modified time.Time) EbookInfo {
+	var chapters []Chapter
+	for i, s := range testStrings[:3] {
+		chapters = append(chapters, Chapter{
+			Title:    []string{"One", "Two", "Three"}[i],
+			Url:      "https://example.com/" + []string{"one", "two", "three"}[i],
+			Content:  dom.Elem("div", dom.Elem("p", dom.Text(s)), dom.Elem("p", dom.Text(s))),
+			Modified: modified.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	return EbookInfo{
+		Authors:  "The Author",
+		Comments: "Some Comments",
+		Title:    "the Title",
+		Source:   "https://example.com/",
+		Language: "en",
+		Chapters: chapters,
+		Modified: modified,
+	}
+}
+
+func TestReadEpub(t *testing.T) {
+	modified := time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC)
+	book := makeTestBook(modified)
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, book.Title, result.Title)
+	expect.Equal(t, book.Authors, result.Authors)
+	expect.Equal(t, book.Comments, result.Comments)
+	expect.Equal(t, book.Source, result.Source)
+	expect.Equal(t, book.Language, result.Language)
+	expect.True(t, book.Modified.Equal(result.Modified))
+	if !expect.Equal(t, len(book.Chapters), len(result.Chapters)) {
+		return
+	}
+	for i, ch := range result.Chapters {
+		expect.Equal(t, book.Chapters[i].Title, ch.Title)
+		expect.Equal(t, book.Chapters[i].Url, ch.Url)
+		expect.True(t, book.Chapters[i].Modified.Equal(ch.Modified))
+		expect.Equal(t, dom.ExtractText(book.Chapters[i].Content), dom.ExtractText(ch.Content))
+		expect.True(t, ch.Content != nil && ch.Content.Parent == nil)
+	}
+}
+
+func TestReadChapterWithoutRule(t *testing.T) {
+	for _, src := range []string{
+		`<h2 class="chapter">Alpha</h2><p>First.</p><p>Second.</p>`,
+		`<h2 class="chapter">Alpha</h2><p><em>2022-05-01</em></p><p>First.</p><p>Second.</p>`,
+	} {
+		doc, err := dom.Parse(strings.NewReader(src))
+		expect.True(t, err == nil)
+		chapter := readChapter(doc, time.Time{})
+		expect.Equal(t, "Alpha", chapter.Title)
+		expect.Equal(t, "First.\n\nSecond.", strings.TrimSpace(dom.ExtractText(chapter.Content)))
+	}
+	doc, err := dom.Parse(strings.NewReader(`<h2 class="chapter">Empty</h2>`))
+	expect.True(t, err == nil)
+	expect.True(t, readChapter(doc, time.Time{}).Content == nil)
+}
@@ -0,0 +1,392 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// Finds elements in a page, with `dom.FindNodesByTagAndAttrib`.
+type Selector struct {
+	// The tag of the elements; empty matches any tag.
+	Tag string `json:"tag,omitempty"`
+	// An attribute of the elements, which must equal Value or match Match;
+	// one of those is required with a Key.
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	// A regular expression, used instead of Value, such as `\bchapter\b`.
+	Match string `json:"match,omitempty"`
+	// If set, only look inside the first element found by this.
+	Within *Selector `json:"within,omitempty"`
+	// The attribute that gives the value of an element; if empty, its text.
+	// For links, the default is "href".
+	Attribute string `json:"attribute,omitempty"`
+
+	match *regexp.Regexp
+}
+
+func (s *Selector) compile() error {
+	if s.Key != "" && s.Value == "" && s.Match == "" {
+		return fmt.Errorf("selector with key %q has no value or match", s.Key)
+	}
+	if s.Match != "" {
+		var err error
+		if s.match, err = regexp.Compile(s.Match); err != nil {
+			return err
+		}
+	}
+	if s.Within != nil {
+		return s.Within.compile()
+	}
+	return nil
+}
+
+func (s *Selector) isEmpty() bool {
+	return s == nil || (s.Tag == "" && s.Key == "")
+}
+
+// Return the elements found in `root`.
+func (s *Selector) find(root *Node) []*Node {
+	if s.isEmpty() || root == nil {
+		return nil
+	}
+	if s.Within != nil {
+		within := s.Within.find(root)
+		if len(within) == 0 {
+			return nil
+		}
+		root = within[0]
+	}
+	if s.match != nil {
+		return dom.FindNodesByTagAndAttribRe(root, s.Tag, s.Key, s.match)
+	}
+	return dom.FindNodesByTagAndAttrib(root, s.Tag, s.Key, s.Value)
+}
+
+// Return the value of an element.
+func (s *Selector) valueOf(node *Node, defaultAttribute string) string {
+	attribute := s.Attribute
+	if attribute == "" {
+		attribute = defaultAttribute
+	}
+	if attribute != "" {
+		return strings.TrimSpace(dom.GetAttribute(node, attribute))
+	}
+	return strings.TrimSpace(whitespaceRegexp.ReplaceAllString(dom.ExtractText(node), " "))
+}
+
+// Return the value of the first element found in `root`, or "".
+func (s *Selector) value(root *Node) string {
+	if nodes := s.find(root); len(nodes) > 0 {
+		return s.valueOf(nodes[0], "")
+	}
+	return ""
+}
+
+// Describes how to make ebooks from the pages of a web site, for books whose
+// pages differ only in where things are found.  Chapters are found either by
+// the links in Chapters on the title page, or by following the Next link from
+// chapter to chapter.  Metadata not found by the rule is found by
+// `PopulateInfo`.
+type SiteRule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Hosts       []string `json:"hosts,omitempty"`    // As in NamedGenerator.
+	Patterns    []string `json:"patterns,omitempty"` // Regular expressions matching URLs.
+	Priority    int      `json:"priority,omitempty"`
+
+	// Found on the title page.
+	Title    Selector `json:"title,omitempty"`
+	Author   Selector `json:"author,omitempty"`
+	Comments Selector `json:"comments,omitempty"`
+	Cover    Selector `json:"cover,omitempty"`    // Its value is the URL of the image.
+	Chapters Selector `json:"chapters,omitempty"` // Links to the chapters.
+	First    Selector `json:"first,omitempty"`    // A link to the first chapter.
+
+	// Found on chapter pages.
+	Next         Selector   `json:"next,omitempty"` // A link to the next chapter.
+	ChapterTitle Selector   `json:"chapterTitle,omitempty"`
+	ChapterDate  Selector   `json:"chapterDate,omitempty"`
+	Content      Selector   `json:"content"`
+	Strip        []Selector `json:"strip,omitempty"` // Elements removed from the content.
+	// The most chapters found by following Next links.  Zero means 10000.
+	MaxChapters int `json:"maxChapters,omitempty"`
+
+	patterns []*regexp.Regexp
+	fetch    PageFetcher
+}
+
+// Check the rule and compile its regular expressions.
+func (rule *SiteRule) compile() error {
+	if rule.Name == "" {
+		return fmt.Errorf("site rule has no name")
+	}
+	if len(rule.Hosts) == 0 && len(rule.Patterns) == 0 {
+		return fmt.Errorf("site rule %q: no hosts or patterns", rule.Name)
+	}
+	if rule.Content.isEmpty() {
+		return fmt.Errorf("site rule %q: no content selector", rule.Name)
+	}
+	if rule.Chapters.isEmpty() && rule.Next.isEmpty() {
+		return fmt.Errorf("site rule %q: no chapters or next selector", rule.Name)
+	}
+	rule.patterns = nil
+	for _, p := range rule.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("site rule %q: %w", rule.Name, err)
+		}
+		rule.patterns = append(rule.patterns, re)
+	}
+	selectors := []*Selector{&rule.Title, &rule.Author, &rule.Comments, &rule.Cover, &rule.Chapters,
+		&rule.First, &rule.Next, &rule.ChapterTitle, &rule.ChapterDate, &rule.Content}
+	for i := range rule.Strip {
+		selectors = append(selectors, &rule.Strip[i])
+	}
+	for _, s := range selectors {
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("site rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Read site rules from JSON: either a single rule or an array of rules.
+func LoadSiteRules(src io.Reader) ([]*SiteRule, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*SiteRule
+	if data = bytes.TrimSpace(data); bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &rules)
+	} else {
+		rule := &SiteRule{}
+		err = json.Unmarshal(data, rule)
+		rules = append(rules, rule)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if err = rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// Read site rules from a JSON file.
+func LoadSiteRulesFile(path string) ([]*SiteRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := LoadSiteRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Register the rule as a generator, using its name, hosts, patterns, and
+// priority.  Returns an error if the rule is invalid or its name is already
+// registered.
+func RegisterSiteRule(rule *SiteRule) error {
+	if err := rule.compile(); err != nil {
+		return err
+	}
+	if err := registerNamedGenerator(rule.namedGenerator()); err != nil {
+		return fmt.Errorf("site rule %q: %w", rule.Name, err)
+	}
+	return nil
+}
+
+func (rule *SiteRule) namedGenerator() NamedGenerator {
+	return NamedGenerator{
+		Name:        rule.Name,
+		Description: rule.Description,
+		Hosts:       rule.Hosts,
+		Patterns:    rule.patterns,
+		Priority:    rule.Priority,
+		Generator:   rule,
+	}
+}
+
+// Make an ebook from the title page at `bookUrl`, following the rule.
+func (rule *SiteRule) GenerateEbook(ctx context.Context, bookUrl string, options GeneratorOptions) (EbookInfo, error) {
+	if !rule.namedGenerator().Matches(bookUrl) {
+		return EbookInfo{}, UnsupportedUrlError
+	}
+	base, err := url.Parse(bookUrl)
+	if err != nil {
+		return EbookInfo{}, err
+	}
+	fetch := rule.fetch
+	if fetch == nil {
+		fetch = getPage
+	}
+	page, err := fetch(ctx, bookUrl, "", options.ForceDownload(true))
+	if err != nil {
+		return EbookInfo{}, err
+	}
+	doc, err := dom.Parse(bytes.NewReader(page))
+	if err != nil {
+		return EbookInfo{}, err
+	}
+
+	info := EbookInfo{
+		Title:    rule.Title.value(doc),
+		Authors:  rule.Author.value(doc),
+		Comments: rule.Comments.value(doc),
+		Source:   bookUrl,
+	}
+	PopulateInfo(&info, doc)
+	if coverUrl := resolveUrl(base, rule.Cover.value(doc)); coverUrl != "" {
+		var coverErr error
+		if info.Cover, coverErr = fetch(ctx, coverUrl, bookUrl, false); coverErr != nil {
+			log.Printf("Cover error: %s: %v", coverUrl, coverErr)
+		}
+	}
+
+	var links []Chapter
+	seen := map[string]bool{}
+	for _, a := range rule.Chapters.find(doc) {
+		href := resolveUrl(base, rule.Chapters.valueOf(a, "href"))
+		if href != "" && !seen[href] {
+			seen[href] = true
+			links = append(links, Chapter{Url: href, Title: rule.Chapters.valueOf(a, "")})
+		}
+	}
+	if !options.Populate {
+		for i, link := range links {
+			if options.IncludesChapter(i) {
+				info.Chapters = append(info.Chapters, link)
+			}
+		}
+		return info, nil
+	}
+
+	fetchOptions := FetchOptions{
+		Referer:  bookUrl,
+		Force:    options.ForceDownload(false),
+		Fetch:    rule.fetch,
+		Progress: options.Progress,
+	}
+	if len(links) > 0 {
+		titles := map[string]string{}
+		var urls []string
+		for i, link := range links {
+			if options.IncludesChapter(i) {
+				titles[link.Url] = link.Title
+				urls = append(urls, link.Url)
+			}
+		}
+		info.Chapters, err = FetchChapters(ctx, urls, func(chapterUrl string, doc *Node) (Chapter, error) {
+			chapter := rule.parseChapter(chapterUrl, doc)
+			if chapter.Title == "" {
+				chapter.Title = titles[chapterUrl]
+			}
+			return chapter, nil
+		}, fetchOptions)
+	} else if !rule.Next.isEmpty() {
+		start := bookUrl
+		if first := rule.First.find(doc); len(first) > 0 {
+			start = resolveUrl(base, rule.First.valueOf(first[0], "href"))
+		}
+		info.Chapters, err = rule.crawl(ctx, start, options, fetchOptions)
+	}
+	if err != nil {
+		return EbookInfo{}, err
+	}
+	info.Cleanup()
+	return info, nil
+}
+
+// Download chapters one after another, following the Next links.
+func (rule *SiteRule) crawl(ctx context.Context, start string, options GeneratorOptions, fetchOptions FetchOptions) ([]Chapter, error) {
+	fetchOptions = fetchOptions.withDefaults()
+	limiter := hostLimiter{interval: fetchOptions.HostInterval, next: map[string]time.Time{}}
+	maxChapters := rule.MaxChapters
+	if maxChapters <= 0 {
+		maxChapters = 10000
+	}
+	var chapters []Chapter
+	visited := map[string]bool{}
+	for i, pageUrl := 0, start; pageUrl != "" && !visited[pageUrl] && i < maxChapters; i++ {
+		if options.LastChapter > 0 && i >= options.LastChapter {
+			break
+		}
+		visited[pageUrl] = true
+		var next string
+		chapter, err := fetchChapter(ctx, pageUrl, func(chapterUrl string, doc *Node) (Chapter, error) {
+			if links := rule.Next.find(doc); len(links) > 0 {
+				if base, err := url.Parse(chapterUrl); err == nil {
+					next = resolveUrl(base, rule.Next.valueOf(links[0], "href"))
+				}
+			}
+			return rule.parseChapter(chapterUrl, doc), nil
+		}, fetchOptions, &limiter)
+		if err != nil {
+			return nil, err
+		}
+		if options.IncludesChapter(i) {
+			chapters = append(chapters, chapter)
+		}
+		options.ReportProgress(i+1, 0, chapter.Title)
+		pageUrl = next
+	}
+	return chapters, nil
+}
+
+// Make a chapter from a chapter page.
+func (rule *SiteRule) parseChapter(chapterUrl string, doc *Node) Chapter {
+	chapter := Chapter{Url: chapterUrl, Title: rule.ChapterTitle.value(doc)}
+	if date := rule.ChapterDate.value(doc); date != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", "January 2, 2006", "2 January 2006"} {
+			if t, err := time.Parse(layout, date); err == nil {
+				chapter.Modified = t
+				break
+			}
+		}
+	}
+	if content := rule.Content.find(doc); len(content) > 0 {
+		chapter.Content = content[0]
+		for i := range rule.Strip {
+			for _, node := range rule.Strip[i].find(chapter.Content) {
+				if node != chapter.Content {
+					dom.Remove(node)
+				}
+			}
+		}
+		dom.Remove(chapter.Content)
+	}
+	return chapter
+}
+
+// Resolve `ref` against `base`; return "" if `ref` is empty or invalid.
+func resolveUrl(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,195 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+const testSiteRules = `[
+{
+	"name": "example-index",
+	"hosts": ["*.example.com"],
+	"patterns": ["/book/\\d+$"],
+	"title": {"tag": "h2", "key": "class", "value": "book-title"},
+	"author": {"tag": "a", "key": "class", "match": "\\bauthor\\b"},
+	"cover": {"tag": "img", "key": "id", "value": "cover", "attribute": "src"},
+	"chapters": {"tag": "a", "within": {"tag": "ul", "key": "id", "value": "toc"}},
+	"chapterTitle": {"tag": "h1"},
+	"chapterDate": {"tag": "time", "attribute": "datetime"},
+	"content": {"tag": "div", "key": "class", "value": "text"},
+	"strip": [{"tag": "div", "key": "class", "value": "ad"}, {"tag": "script"}]
+},
+{
+	"name": "example-crawl",
+	"hosts": ["stories.example.org"],
+	"first": {"tag": "a", "key": "rel", "value": "first"},
+	"next": {"tag": "a", "key": "rel", "value": "next"},
+	"chapterTitle": {"tag": "h1"},
+	"content": {"tag": "article"}
+}
+]`
+
+var testSitePages = map[string]string{
+	"https://www.example.com/book/1": `<html lang="en"><body>
+<h2 class="book-title">Rules</h2><a class="big author" href="/u/1">Ann Author</a>
+<img id="cover" src="/cover.jpg"/>
+<ul id="toc"><li><a href="/ch/1">One</a></li><li><a href="/ch/2#top">Two</a></li>
+<li><a href="/ch/2">Two again</a></li><li><a href="ch/3">Three</a></li></ul>
+<a href="/elsewhere">Not a chapter</a></body></html>`,
+	"https://www.example.com/cover.jpg": "not really a jpeg",
+	"https://www.example.com/ch/1": `<h1>Chapter One</h1><time datetime="2022-05-01"></time>
+<div class="text"><p>First.</p><div class="ad">Buy!</div><script>x()</script></div>`,
+	"https://www.example.com/ch/2":      `<div class="text"><p>Second.</p></div>`,
+	"https://www.example.com/book/ch/3": `<h1>Chapter Three</h1><div class="text"><p>Third.</p></div>`,
+
+	"https://stories.example.org/s/1":   `<h1>A Story</h1><a rel="first" href="/s/1/a">Start</a>`,
+	"https://stories.example.org/s/1/a": `<h1>A</h1><article><p>a</p></article><a rel="next" href="b">Next</a>`,
+	"https://stories.example.org/s/1/b": `<h1>B</h1><article><p>b</p></article><a rel="next" href="c">Next</a>`,
+	"https://stories.example.org/s/1/c": `<h1>C</h1><article><p>c</p></article><a rel="next" href="a">Next</a>`,
+}
+
+func loadTestSiteRules(t *testing.T) []*SiteRule {
+	rules, err := LoadSiteRules(strings.NewReader(testSiteRules))
+	expect.True(t, err == nil)
+	expect.Equal(t, 2, len(rules))
+	for _, rule := range rules {
+		rule.fetch = func(ctx context.Context, url, referer string, force bool) ([]byte, error) {
+			if page, ok := testSitePages[url]; ok {
+				return []byte(page), nil
+			}
+			return nil, errors.New("not found: " + url)
+		}
+	}
+	return rules
+}
+
+func TestSiteRuleIndex(t *testing.T) {
+	rule := loadTestSiteRules(t)[0]
+	ctx := context.Background()
+
+	_, err := rule.GenerateEbook(ctx, "https://www.example.com/book/1/reviews", GeneratorOptions{})
+	expect.True(t, err == UnsupportedUrlError)
+
+	info, err := rule.GenerateEbook(ctx, "https://www.example.com/book/1", GeneratorOptions{})
+	expect.True(t, err == nil)
+	expect.Equal(t, "Rules", info.Title)
+	expect.Equal(t, "Ann Author", info.Authors)
+	expect.Equal(t, "en", info.Language)
+	expect.Equal(t, "https://www.example.com/book/1", info.Source)
+	expect.Equal(t, "not really a jpeg", string(info.Cover))
+	expect.Equal(t, 3, len(info.Chapters))
+	expect.Equal(t, "https://www.example.com/ch/2", info.Chapters[1].Url)
+	expect.Equal(t, "https://www.example.com/book/ch/3", info.Chapters[2].Url)
+	expect.Equal(t, "Two", info.Chapters[1].Title)
+	expect.True(t, info.Chapters[0].Content == nil)
+
+	var done []int
+	info, err = rule.GenerateEbook(ctx, "https://www.example.com/book/1", GeneratorOptions{
+		Populate: true,
+		Progress: func(n, total int, message string) { done = append(done, n) },
+	})
+	expect.True(t, err == nil)
+	expect.DeepEqual(t, []int{1, 2, 3}, done)
+	expect.Equal(t, 3, len(info.Chapters))
+	expect.Equal(t, "Chapter One", info.Chapters[0].Title)
+	expect.Equal(t, "Two", info.Chapters[1].Title)
+	expect.Equal(t, "2022-05-01", info.Chapters[0].Modified.Format("2006-01-02"))
+	expect.Equal(t, "First.", strings.TrimSpace(dom.ExtractText(info.Chapters[0].Content)))
+	expect.Equal(t, "Third.", strings.TrimSpace(dom.ExtractText(info.Chapters[2].Content)))
+
+	info, err = rule.GenerateEbook(ctx, "https://www.example.com/book/1", GeneratorOptions{
+		Populate: true, FirstChapter: 2, LastChapter: 2,
+	})
+	expect.True(t, err == nil)
+	expect.Equal(t, 1, len(info.Chapters))
+	expect.Equal(t, "Second.", strings.TrimSpace(dom.ExtractText(info.Chapters[0].Content)))
+}
+
+func TestSiteRuleMissingCover(t *testing.T) {
+	rule := loadTestSiteRules(t)[0]
+	fetch := rule.fetch
+	rule.fetch = func(ctx context.Context, url, referer string, force bool) ([]byte, error) {
+		if url == "https://www.example.com/book/2" {
+			return []byte(`<h2 class="book-title">No Chapters</h2><img id="cover" src="/missing.jpg"/>`), nil
+		}
+		return fetch(ctx, url, referer, force)
+	}
+	info, err := rule.GenerateEbook(context.Background(), "https://www.example.com/book/2", GeneratorOptions{Populate: true})
+	expect.True(t, err == nil)
+	expect.Equal(t, "No Chapters", info.Title)
+	expect.Equal(t, 0, len(info.Cover))
+	expect.Equal(t, 0, len(info.Chapters))
+}
+
+func TestSiteRuleCrawl(t *testing.T) {
+	rule := loadTestSiteRules(t)[1]
+	info, err := rule.GenerateEbook(context.Background(), "https://stories.example.org/s/1", GeneratorOptions{Populate: true})
+	expect.True(t, err == nil)
+	expect.Equal(t, "A Story", info.Title)
+	var titles []string
+	for _, chapter := range info.Chapters {
+		titles = append(titles, chapter.Title)
+	}
+	// The link from the last chapter back to the first is not followed.
+	expect.DeepEqual(t, []string{"A", "B", "C"}, titles)
+	expect.Equal(t, "https://stories.example.org/s/1/b", info.Chapters[1].Url)
+
+	info, err = rule.GenerateEbook(context.Background(), "https://stories.example.org/s/1", GeneratorOptions{
+		Populate: true, LastChapter: 2,
+	})
+	expect.True(t, err == nil)
+	expect.Equal(t, 2, len(info.Chapters))
+}
+
+func TestLoadSiteRules(t *testing.T) {
+	rules, err := LoadSiteRules(strings.NewReader(`{"name": "one", "hosts": ["a.example"],
+		"chapters": {"tag": "a"}, "content": {"tag": "main"}}`))
+	expect.True(t, err == nil)
+	expect.Equal(t, 1, len(rules))
+	expect.Equal(t, "one", rules[0].Name)
+
+	for _, src := range []string{
+		`{"hosts": ["a.example"], "chapters": {"tag": "a"}, "content": {"tag": "main"}}`,
+		`{"name": "x", "chapters": {"tag": "a"}, "content": {"tag": "main"}}`,
+		`{"name": "x", "hosts": ["a.example"], "chapters": {"tag": "a"}}`,
+		`{"name": "x", "hosts": ["a.example"], "content": {"tag": "main"}}`,
+		`{"name": "x", "patterns": ["("], "chapters": {"tag": "a"}, "content": {"tag": "main"}}`,
+		`{"name": "x", "hosts": ["a.example"], "chapters": {"tag": "a", "key": "class", "match": "["}, "content": {"tag": "main"}}`,
+		`{"name": "x", "hosts": ["a.example"], "chapters": {"tag": "a"}, "content": {"key": "id"}}`,
+		`[{"name": 1}]`,
+	} {
+		_, err := LoadSiteRules(strings.NewReader(src))
+		expect.True(t, err != nil)
+	}
+}
+
+func TestRegisterSiteRule(t *testing.T) {
+	registerdFunctionsMutex.Lock()
+	saved := registerdFunctions
+	registerdFunctions = nil
+	registerdFunctionsMutex.Unlock()
+	defer func() {
+		registerdFunctionsMutex.Lock()
+		registerdFunctions = saved
+		registerdFunctionsMutex.Unlock()
+	}()
+
+	rules := loadTestSiteRules(t)
+	expect.True(t, RegisterSiteRule(rules[0]) == nil)
+	expect.True(t, RegisterSiteRule(rules[1]) == nil)
+	duplicate := *rules[0]
+	expect.True(t, RegisterSiteRule(&duplicate) != nil)
+	expect.Equal(t, 2, len(ListEbookGenerators()))
+
+	generator, ok := GeneratorFor("https://www.example.com/book/1")
+	expect.True(t, ok)
+	expect.Equal(t, "example-index", generator.Name)
+}
@@ -0,0 +1,132 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// The default of `EpubOptions.MaxChapterSize`.
+const DefaultMaxChapterSize = 256 * 1024
+
+// Elements that may be split between files, each part getting a copy of the
+// element.
+var splittableElements = map[string]bool{
+	"article": true, "body": true, "div": true, "main": true, "section": true,
+}
+
+type byteCounter int
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
+
+// Return the size of `node`, rendered as XHTML.
+func xhtmlSize(node *Node) int {
+	var counter byteCounter
+	dom.RenderXHTMLDoc(node, &counter)
+	return int(counter)
+}
+
+// Return a copy of the element without its children.
+func shallowCopy(node *Node, keepId bool) *Node {
+	result := &Node{Type: node.Type, Data: node.Data, DataAtom: node.DataAtom, Namespace: node.Namespace}
+	for _, attr := range node.Attr {
+		if keepId || attr.Namespace != "" || attr.Key != "id" {
+			result.Attr = append(result.Attr, attr)
+		}
+	}
+	return result
+}
+
+// Split the content of a chapter between its children into parts of about
+// `limit` bytes.  Oversized children that are containers are split too.  The
+// children of `content` are moved into the parts.
+func splitContent(content *Node, limit int) []*Node {
+	if content == nil || content.Type != dom.ElementNode || xhtmlSize(content) <= limit {
+		return []*Node{content}
+	}
+	var children []*Node
+	for child := content.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+	}
+	var parts []*Node
+	var part *Node
+	size := 0
+	for _, child := range children {
+		content.RemoveChild(child)
+		units := []*Node{child}
+		if child.Type == dom.ElementNode && splittableElements[child.Data] && xhtmlSize(child) > limit {
+			units = splitContent(child, limit)
+		}
+		for _, unit := range units {
+			if part != nil && isWhitespaceOnly(unit) {
+				part.AppendChild(unit)
+				continue
+			}
+			unitSize := xhtmlSize(unit)
+			if part != nil && size > 0 && size+unitSize > limit {
+				parts = append(parts, part)
+				part = nil
+			}
+			if part == nil {
+				part = shallowCopy(content, len(parts) == 0)
+				size = 0
+			}
+			part.AppendChild(unit)
+			size += unitSize
+		}
+	}
+	if part != nil {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// The base name of part `part` of chapter `chapter`.
+func chapterPartName(chapter, part int) string {
+	if part == 0 {
+		return fmt.Sprintf("%04d", chapter)
+	}
+	return fmt.Sprintf("%04d-%d", chapter, part)
+}
+
+// Make links to fragments in other parts of a split chapter point to the
+// right file.
+func fixPartLinks(parts []*Node, chapter int) {
+	ids := map[string]int{}
+	for i, part := range parts {
+		for _, node := range dom.FindNodesByTagAndAttrib(part, "", "", "") {
+			if id := dom.GetAttribute(node, "id"); id != "" {
+				if _, ok := ids[id]; !ok {
+					ids[id] = i
+				}
+			}
+		}
+	}
+	for i, part := range parts {
+		for _, a := range dom.FindNodesByTagAndAttrib(part, "a", "", "") {
+			if href := getNodeAttribute(a, "href"); href != nil && strings.HasPrefix(href.Val, "#") {
+				if j, ok := ids[href.Val[1:]]; ok && j != i {
+					href.Val = chapterPartName(chapter, j) + ".xhtml" + href.Val
+				}
+			}
+		}
+	}
+}
+
+// Undo `fixPartLinks`, for the parts in `names`.
+func unfixPartLinks(content *Node, names map[string]bool) {
+	for _, a := range dom.FindNodesByTagAndAttrib(content, "a", "", "") {
+		if href := getNodeAttribute(a, "href"); href != nil {
+			if name, fragment, found := strings.Cut(href.Val, "#"); found && names[name] {
+				href.Val = "#" + fragment
+			}
+		}
+	}
+}
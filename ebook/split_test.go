@@ -0,0 +1,75 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestSplitChapters(t *testing.T) {
+	modified := time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC)
+	book := makeTestBook(modified)
+	content := dom.Element("div", dom.Attr{"id": "top"},
+		dom.Elem("p", dom.Element("a", dom.Attr{"href": "#target"}, dom.Text("jump"))))
+	for i := 0; i < 30; i++ {
+		p := dom.Elem("p", dom.Text(fmt.Sprintf("Paragraph %d. %s", i, testStrings[0][:60])))
+		if i == 25 {
+			dom.AddAttribute(p, "id", "target")
+		}
+		dom.Append(content, p)
+	}
+	book.Chapters[0].Content = content
+	text := dom.ExtractText(content)
+	options := EpubOptions{MaxChapterSize: 1000}
+
+	var buffer bytes.Buffer
+	if err := book.WriteEpub(&buffer, options); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	parts := 1
+	for entries[fmt.Sprintf("book/0000-%d.xhtml", parts)] != "" {
+		expect.True(t, len(entries[fmt.Sprintf("book/0000-%d.xhtml", parts)]) < 1500)
+		expect.True(t, strings.Contains(entries["book/content.opf"], fmt.Sprintf(`<itemref idref="ch0000-%d"/>`, parts)))
+		parts++
+	}
+	expect.True(t, parts > 3)
+	expect.True(t, strings.Contains(entries["book/0000.xhtml"], `<div id="top">`))
+	expect.True(t, !strings.Contains(entries["book/0000-1.xhtml"], `id="top"`))
+	expect.True(t, !strings.Contains(entries["book/toc.xhtml"], "0000-1.xhtml"))
+	var target string
+	for i := 1; i < parts; i++ {
+		if strings.Contains(entries[fmt.Sprintf("book/0000-%d.xhtml", i)], `id="target"`) {
+			target = fmt.Sprintf("0000-%d.xhtml#target", i)
+		}
+	}
+	expect.True(t, strings.Contains(entries["book/0000.xhtml"], `<a href="`+target+`">`))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect.Equal(t, 3, len(result.Chapters)) {
+		expect.Equal(t, text, dom.ExtractText(result.Chapters[0].Content))
+		expect.Equal(t, "#target", dom.GetAttribute(dom.FindNodeByTag(result.Chapters[0].Content, "a"), "href"))
+	}
+
+	// Every part of an unchanged chapter is copied.
+	book.Chapters[0].Content = dom.Elem("p", dom.Text("ignored"))
+	var updated bytes.Buffer
+	if err := book.UpdateEpub(&updated, bytes.NewReader(data), int64(len(data)), options); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, updated.Bytes())
+	expect.DeepEqual(t, entries, readZipEntries(t, updated.Bytes()))
+}
@@ -0,0 +1,43 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+// Built-in stylesheets, for use as `EbookInfo.Style`.
+const (
+	// Black on white, with no gray, for e-ink screens.
+	ThemeEInk = DefaultStyle + `
+body {color:#000;background-color:#fff;}
+a {color:#000;text-decoration:underline;}
+table, th, td {border-color:#000;}
+hr {border:0;border-top:2px solid #000;}
+`
+	// Small margins and tight spacing, for small screens.
+	ThemeCompact = DefaultStyle + `
+body {margin:0;padding:0 0.5em;line-height:1.2;}
+h1, h2, h3 {margin:0.5em 0 0.25em 0;}
+div p {text-indent:1em;}
+hr {margin:0.25em 0;}
+`
+	// Justified, hyphenated, indented prose in a serif font.
+	ThemeTraditional = DefaultStyle + `
+body {font-family:serif;line-height:1.4;}
+div p {text-align:justify;text-indent:1.5em;hyphens:auto;-webkit-hyphens:auto;}
+div p:first-child {text-indent:0;}
+h2.chapter {text-align:center;font-variant:small-caps;}
+hr {width:30%;margin:1em auto;}
+`
+)
+
+// Return the stylesheet of the book: `Style` (or `DefaultStyle`), followed by
+// `ExtraStyle`.
+func (info EbookInfo) Stylesheet() string {
+	style := info.Style
+	if style == "" {
+		style = DefaultStyle
+	}
+	if info.ExtraStyle != "" {
+		style += "\n" + info.ExtraStyle + "\n"
+	}
+	return style
+}
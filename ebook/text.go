@@ -0,0 +1,354 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/HalCanary/facility/dom"
+	"golang.org/x/text/width"
+)
+
+// The line width used by `WriteText` if none is given.
+const DefaultTextWidth = 72
+
+// Write the ebook as UTF-8 plain text, with paragraphs wrapped to `lineWidth`
+// columns (or `DefaultTextWidth`, if `lineWidth` is not positive).  East
+// Asian wide characters count as two columns.
+func (info EbookInfo) WriteText(dst io.Writer, lineWidth int) error {
+	if lineWidth <= 0 {
+		lineWidth = DefaultTextWidth
+	}
+	c := textConverter{width: lineWidth}
+	separator := strings.Repeat("=", lineWidth)
+	var blocks []string
+	title := c.wrap(info.Title)
+	if title != "" {
+		blocks = append(blocks, title+"\n"+strings.Repeat("=", maxLineWidth(title)))
+	}
+	var byline []string
+	if authors := info.AuthorNames(); authors != "" {
+		byline = append(byline, c.wrap("by "+authors))
+	}
+	for _, line := range otherContributors(info) {
+		byline = append(byline, c.wrap(line))
+	}
+	if info.Series != "" {
+		series := "Series: " + info.Series
+		if info.SeriesIndex != 0 {
+			series += " #" + formatSeriesIndex(info.SeriesIndex)
+		}
+		byline = append(byline, c.wrap(series))
+	}
+	if info.Source != "" {
+		byline = append(byline, "Source: "+info.Source)
+	}
+	if !info.Modified.IsZero() {
+		byline = append(byline, "Modified: "+info.Modified.Format("2006-01-02"))
+	}
+	if len(byline) > 0 {
+		blocks = append(blocks, strings.Join(byline, "\n"))
+	}
+	for _, p := range strings.Split(info.Comments, "\n\n") {
+		if p = c.wrap(whitespaceRegexp.ReplaceAllString(p, " ")); p != "" {
+			blocks = append(blocks, p)
+		}
+	}
+
+	walkToc(makeToc(info.Chapters), func(e *tocEntry) {
+		heading := c.wrap(e.label())
+		underline := "-"
+		if e.Chapter < 0 {
+			underline = "="
+		}
+		blocks = append(blocks, separator,
+			heading+"\n"+strings.Repeat(underline, maxLineWidth(heading)))
+		if e.Chapter < 0 {
+			return
+		}
+		chapter := info.Chapters[e.Chapter]
+		var header []string
+		if chapter.Url != "" {
+			header = append(header, chapter.Url)
+		}
+		if !chapter.Modified.IsZero() {
+			header = append(header, chapter.Modified.Format("2006-01-02"))
+		}
+		if len(header) > 0 {
+			blocks = append(blocks, strings.Join(header, "\n"))
+		}
+		if chapter.Content != nil {
+			blocks = append(blocks, c.blocks(chapter.Content)...)
+		}
+	})
+	blocks = append(blocks, separator)
+	_, err := io.WriteString(dst, strings.Join(blocks, "\n\n")+"\n")
+	return err
+}
+
+// Return the number of columns that a rune occupies.
+func runeWidth(r rune) int {
+	if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || r == '\u200b' {
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// Return the number of columns that a string occupies.
+func textWidth(s string) int {
+	result := 0
+	for _, r := range s {
+		result += runeWidth(r)
+	}
+	return result
+}
+
+// Return the width of the widest line of `s`.
+func maxLineWidth(s string) int {
+	result := 0
+	for _, line := range strings.Split(s, "\n") {
+		if w := textWidth(line); w > result {
+			result = w
+		}
+	}
+	return result
+}
+
+// Converts HTML content to plain text.
+type textConverter struct {
+	width int
+}
+
+// Wrap each line of `s` at spaces to fit in the converter's width.  Words
+// that are too wide, such as runs of CJK text, are broken between characters.
+func (c textConverter) wrap(s string) string {
+	lineWidth := c.width
+	if lineWidth < minTextWidth {
+		lineWidth = minTextWidth
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		var line strings.Builder
+		column := 0
+		for _, word := range strings.Fields(paragraph) {
+			w := textWidth(word)
+			if column > 0 && column+1+w <= lineWidth {
+				line.WriteString(" ")
+				column++
+			} else if column > 0 {
+				lines = append(lines, line.String())
+				line.Reset()
+				column = 0
+			}
+			for _, r := range word {
+				rw := runeWidth(r)
+				if column > 0 && column+rw > lineWidth {
+					lines = append(lines, line.String())
+					line.Reset()
+					column = 0
+				}
+				line.WriteRune(r)
+				column += rw
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// The narrowest width that content is wrapped to, however deeply indented.
+const minTextWidth = 8
+
+// Return a converter for content indented by `indent` columns.
+func (c textConverter) indented(indent int) textConverter {
+	width := c.width - indent
+	if width < minTextWidth {
+		width = minTextWidth
+	}
+	return textConverter{width: width}
+}
+
+// Return the text blocks for the children of `node`.
+func (c textConverter) blocks(node *Node) []string {
+	var result []string
+	var inline strings.Builder
+	flush := func() {
+		if text := c.wrap(inline.String()); text != "" {
+			result = append(result, text)
+		}
+		inline.Reset()
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == dom.ElementNode && isBlockElement(child.Data) {
+			flush()
+			result = append(result, c.block(child)...)
+		} else {
+			inline.WriteString(c.inline(child))
+		}
+	}
+	flush()
+	return result
+}
+
+// Return the text blocks for a block element.
+func (c textConverter) block(node *Node) []string {
+	switch node.Data {
+	case "script", "style", "head", "title":
+		return nil
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := c.wrap(strings.ReplaceAll(c.inlineChildren(node), "\n", " "))
+		if text == "" {
+			return nil
+		}
+		underline := "-"
+		if node.Data == "h1" {
+			underline = "="
+		}
+		return []string{text + "\n" + strings.Repeat(underline, maxLineWidth(text))}
+	case "hr":
+		const rule = "* * *"
+		padding := (c.width - len(rule)) / 2
+		if padding < 0 {
+			padding = 0
+		}
+		return []string{strings.Repeat(" ", padding) + rule}
+	case "pre":
+		text := strings.TrimRight(strings.TrimPrefix(rawText(node), "\n"), "\n ")
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	case "blockquote":
+		const indent = "    "
+		quoted := c.indented(len(indent)).blocks(node)
+		return []string{prefixLines(strings.Join(quoted, "\n\n"), indent, indent)}
+	case "ul", "ol":
+		return []string{c.list(node)}
+	case "table":
+		if table := c.table(node); table != "" {
+			return []string{table}
+		}
+		return nil
+	}
+	return c.blocks(node)
+}
+
+func (c textConverter) list(node *Node) string {
+	number := 1
+	if start, err := strconv.Atoi(dom.GetAttribute(node, "start")); err == nil {
+		number = start
+	}
+	var markers []string
+	var items []*Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if isElement(child, "li") {
+			marker := "* "
+			if node.Data == "ol" {
+				marker = fmt.Sprintf("%d. ", number)
+				number++
+			}
+			markers = append(markers, marker)
+			items = append(items, child)
+		}
+	}
+	indent := 0
+	for _, marker := range markers {
+		if len(marker) > indent {
+			indent = len(marker)
+		}
+	}
+	var result []string
+	for i, item := range items {
+		text := strings.Join(c.indented(indent).blocks(item), "\n")
+		marker := strings.Repeat(" ", indent-len(markers[i])) + markers[i]
+		result = append(result, prefixLines(text, marker, strings.Repeat(" ", indent)))
+	}
+	return strings.Join(result, "\n")
+}
+
+// Return the table with its columns aligned.  If the first row consists of
+// header cells, it is underlined.
+func (c textConverter) table(node *Node) string {
+	var rows [][]string
+	var widths []int
+	header := false
+	for i, tr := range dom.FindNodesByTagAndAttrib(node, "tr", "", "") {
+		var row []string
+		for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+			if isElement(cell, "td") || isElement(cell, "th") {
+				if i == 0 && isElement(cell, "th") {
+					header = true
+				}
+				text := strings.Join(strings.Fields(c.inlineChildren(cell)), " ")
+				if len(row) == len(widths) {
+					widths = append(widths, 0)
+				}
+				if w := textWidth(text); w > widths[len(row)] {
+					widths[len(row)] = w
+				}
+				row = append(row, text)
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(widths) == 0 {
+		return ""
+	}
+	var lines []string
+	for i, row := range rows {
+		var line strings.Builder
+		for j, cell := range row {
+			if j > 0 {
+				line.WriteString("  ")
+			}
+			line.WriteString(cell)
+			line.WriteString(strings.Repeat(" ", widths[j]-textWidth(cell)))
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+		if i == 0 && header {
+			var rule []string
+			for _, w := range widths {
+				rule = append(rule, strings.Repeat("-", w))
+			}
+			lines = append(lines, strings.Join(rule, "  "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c textConverter) inlineChildren(node *Node) string {
+	var b strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(c.inline(child))
+	}
+	return b.String()
+}
+
+// Return the text of an inline node.  Line breaks are kept; other whitespace
+// is collapsed.
+func (c textConverter) inline(node *Node) string {
+	switch node.Type {
+	case dom.TextNode:
+		return whitespaceRegexp.ReplaceAllString(node.Data, " ")
+	case dom.ElementNode:
+		switch node.Data {
+		case "br":
+			return "\n"
+		case "img":
+			return dom.GetAttribute(node, "alt")
+		case "script", "style":
+			return ""
+		}
+		return c.inlineChildren(node)
+	}
+	return ""
+}
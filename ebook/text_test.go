@@ -0,0 +1,94 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestWriteText(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Chapters = book.Chapters[:1]
+	book.Contributors = []Contributor{{Name: "The Author"}, {Name: "Tr", Role: RoleTranslator}}
+	doc, err := dom.Parse(strings.NewReader(`<div>
+<p>The quick brown fox jumps over the lazy dog.  The quick brown fox jumps<br/>over the lazy dog.</p>
+<p>日本語の文章は空白なしで折り返されます。</p>
+<blockquote><p>Quoted text that is long enough to wrap.</p></blockquote>
+<ol start="9"><li>nine</li><li>ten<ul><li>sub</li></ul></li></ol>
+<table><tr><th>Name</th><th>Qty</th></tr><tr><td>apple</td><td>10</td></tr><tr><td>梨</td><td>2</td></tr></table>
+</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Chapters[0].Content = dom.FindNodeByTag(doc, "div")
+	var buffer bytes.Buffer
+	if err := book.WriteText(&buffer, 30); err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, `the Title
+=========
+
+by The Author
+Translator: Tr
+Source: https://example.com/
+Modified: 2022-10-01
+
+Some Comments
+
+==============================
+
+1. One
+------
+
+https://example.com/one
+2022-10-01
+
+The quick brown fox jumps over
+the lazy dog. The quick brown
+fox jumps
+over the lazy dog.
+
+日本語の文章は空白なしで折り返
+されます。
+
+    Quoted text that is long
+    enough to wrap.
+
+ 9. nine
+10. ten
+    * sub
+
+Name   Qty
+-----  ---
+apple  10
+梨     2
+
+==============================
+`, buffer.String())
+}
+
+func TestWriteTextNarrow(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Chapters = book.Chapters[:1]
+	doc, err := dom.Parse(strings.NewReader(`<div><p>Some words.</p><hr/>` +
+		strings.Repeat("<blockquote>", 20) + `<p>Deep.</p><hr/>` + strings.Repeat("</blockquote>", 20) + `</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Chapters[0].Content = dom.FindNodeByTag(doc, "div")
+	for _, width := range []int{2, 72} {
+		var buffer bytes.Buffer
+		if err := book.WriteText(&buffer, width); err != nil {
+			t.Fatal(err)
+		}
+		expect.True(t, strings.Contains(buffer.String(), "Deep."))
+		expect.True(t, strings.Contains(buffer.String(), "* * *"))
+	}
+}
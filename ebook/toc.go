@@ -0,0 +1,127 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// An entry in the table of contents: either a section or a chapter.
+type tocEntry struct {
+	Title    string
+	Chapter  int // Index into EbookInfo.Chapters, or -1 for a section.
+	Section  int // Index of the section heading page, or -1 for a chapter.
+	Depth    int
+	Children []*tocEntry
+}
+
+// Group chapters into nested sections, based on `Chapter.Sections`.
+// Consecutive chapters that share a section title are grouped together.
+func makeToc(chapters []Chapter) []*tocEntry {
+	var root []*tocEntry
+	var stack []*tocEntry // open sections, outermost first.
+	sectionCount := 0
+	for i, chapter := range chapters {
+		common := 0
+		for common < len(stack) && common < len(chapter.Sections) && stack[common].Title == chapter.Sections[common] {
+			common++
+		}
+		stack = stack[:common]
+		for _, title := range chapter.Sections[common:] {
+			section := &tocEntry{Title: title, Chapter: -1, Section: sectionCount, Depth: len(stack)}
+			sectionCount++
+			appendTocEntry(&root, stack, section)
+			stack = append(stack, section)
+		}
+		appendTocEntry(&root, stack, &tocEntry{Title: chapter.Title, Chapter: i, Section: -1, Depth: len(stack)})
+	}
+	return root
+}
+
+func appendTocEntry(root *[]*tocEntry, stack []*tocEntry, entry *tocEntry) {
+	if len(stack) == 0 {
+		*root = append(*root, entry)
+	} else {
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, entry)
+	}
+}
+
+// Call `fn` on every entry, in reading order.
+func walkToc(entries []*tocEntry, fn func(*tocEntry)) {
+	for _, entry := range entries {
+		fn(entry)
+		walkToc(entry.Children, fn)
+	}
+}
+
+// Return the number of levels in the table of contents.
+func tocDepth(entries []*tocEntry) int {
+	depth := 0
+	walkToc(entries, func(e *tocEntry) {
+		if e.Depth+1 > depth {
+			depth = e.Depth + 1
+		}
+	})
+	return depth
+}
+
+// The base name of the file holding the entry, and its manifest id.
+func (e *tocEntry) fileName() (name, id string) {
+	if e.Chapter < 0 {
+		name = fmt.Sprintf("section%04d", e.Section)
+		return name, name
+	}
+	name = fmt.Sprintf("%04d", e.Chapter)
+	return name, "ch" + name
+}
+
+// The label used for the entry in the table of contents.
+func (e *tocEntry) label() string {
+	if e.Chapter < 0 {
+		return e.Title
+	}
+	return fmt.Sprintf("%d. %s", e.Chapter+1, e.Title)
+}
+
+// Return a nested list of links to the entries.
+func tocList(entries []*tocEntry, href func(*tocEntry) string) *Node {
+	list := dom.Elem("ol")
+	for _, entry := range entries {
+		item := dom.Elem("li", link(href(entry), entry.label()))
+		if len(entry.Children) > 0 {
+			dom.Append(item, tocList(entry.Children, href))
+		}
+		dom.Append(list, item)
+	}
+	return list
+}
+
+func writeSection(title, lang string, epub2 bool, dst io.Writer) error {
+	htmlNode := dom.Element("html",
+		dom.Attr{
+			"xmlns":      "http://www.w3.org/1999/xhtml",
+			"xml:lang":   lang,
+			"xmlns:epub": "http://www.idpf.org/2007/ops",
+		},
+		head(title, ""),
+		dom.Element("body", dom.Attr{"epub:type": "part"},
+			dom.Element("h1", dom.Attr{"class": "section"}, dom.Text(title))),
+	)
+	return renderContentDoc(htmlNode, epub2, dst)
+}
+
+// Return true if the document was written by `writeSection`.
+func isSectionPage(doc *Node) bool {
+	body := dom.FindNodeByTag(doc, "body")
+	if body == nil {
+		return false
+	}
+	heading := nextElement(body.FirstChild)
+	return isElement(heading, "h1") && dom.GetAttribute(heading, "class") == "section" &&
+		nextElement(heading.NextSibling) == nil
+}
@@ -0,0 +1,57 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/expect"
+)
+
+func TestSections(t *testing.T) {
+	book := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC))
+	book.Chapters[0].Sections = []string{"Volume 1", "Arc 1"}
+	book.Chapters[1].Sections = []string{"Volume 1", "Arc 2"}
+	book.Chapters[2].Sections = []string{"Volume 2"}
+
+	toc := makeToc(book.Chapters)
+	expect.Equal(t, 2, len(toc))
+	expect.Equal(t, 3, tocDepth(toc))
+	var order []string
+	walkToc(toc, func(e *tocEntry) {
+		name, _ := e.fileName()
+		order = append(order, name)
+	})
+	expect.Equal(t, "section0000 section0001 0000 section0002 0001 section0003 0002", strings.Join(order, " "))
+
+	var buffer bytes.Buffer
+	if err := book.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+	entries := readZipEntries(t, buffer.Bytes())
+	expect.True(t, strings.Contains(entries["book/toc.ncx"], `<meta name="dtb:depth" content="3"/>`))
+	expect.True(t, strings.Contains(entries["book/section0001.xhtml"], `<h1 class="section">Arc 1</h1>`))
+
+	data := buffer.Bytes()
+	result, err := ReadEpub(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect.Equal(t, len(book.Chapters), len(result.Chapters)) {
+		for i, ch := range result.Chapters {
+			expect.DeepEqual(t, book.Chapters[i].Sections, ch.Sections)
+		}
+	}
+
+	buffer.Reset()
+	if err := book.WriteHtml(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expect.True(t, strings.Contains(buffer.String(), `<a href="#sec003">Volume 2</a>`))
+	expect.True(t, strings.Contains(buffer.String(), `<h1 class="chapter" id="sec003">Volume 2</h1>`))
+}
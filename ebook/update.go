@@ -0,0 +1,117 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/HalCanary/facility/dom"
+)
+
+// The files of a chapter copied from an existing Epub.
+type reusedChapter struct {
+	Files  []*zip.File // The parts of the chapter, in order.
+	Images []epubImage
+}
+
+// Write the ebook as an Epub, like `WriteEpub`, but copy unchanged chapter
+// files (including every part of a split chapter) from `old`, an Epub
+// previously written by this package, without rendering or recompressing
+// them.  A chapter is unchanged if its title, URL and modification time are
+// the same; chapters with no modification time are always rendered, as are
+// all chapters if `options.Epub2` does not match the version of `old`, or if
+// the options that shape chapter files (MaxChapterSize, Endnotes, KeepNotes)
+// differ from those `old` was written with.  The table of contents and
+// metadata are always rewritten.
+func (info EbookInfo) UpdateEpub(dst io.Writer, old io.ReaderAt, oldSize int64, options EpubOptions) error {
+	zr, err := zip.NewReader(old, oldSize)
+	if err != nil {
+		return err
+	}
+	r := epubReader{files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		r.files[f.Name] = f
+	}
+	opfPath, err := r.rootfile()
+	if err != nil {
+		return err
+	}
+	if opfPath != "book/content.opf" {
+		return errors.New("not an Epub written by this package")
+	}
+	var opf opfDocument
+	if err = r.readXml(opfPath, &opf); err != nil {
+		return err
+	}
+	mediaTypes := map[string]string{}
+	for _, item := range opf.ManifestItems {
+		mediaTypes[resolveHref(opfPath, item.Href)] = item.MediaType
+	}
+	oldCount := 0
+	for r.files[fmt.Sprintf("book/%04d.xhtml", oldCount)] != nil {
+		oldCount++
+	}
+	layout := ""
+	for _, elem := range opf.Metadata.Elements {
+		if elem.XMLName.Local == "meta" && xmlAttribute(elem.Attributes, "name") == chapterLayoutMeta {
+			layout = xmlAttribute(elem.Attributes, "content")
+		}
+	}
+	if strings.HasPrefix(opf.Version, "2.") != options.Epub2 || layout != options.chapterLayout() {
+		oldCount = 0 // The chapters are written differently.
+	}
+
+	reuse := map[int]reusedChapter{}
+	for i, chapter := range info.Chapters {
+		// The last chapter ends with a link to its source; others do not.
+		if chapter.Modified.IsZero() || i >= oldCount || (i+1 == len(info.Chapters)) != (i+1 == oldCount) {
+			continue
+		}
+		name := fmt.Sprintf("book/%04d.xhtml", i)
+		f := r.files[name]
+		// Archive timestamps have a precision of one second.
+		if f.Modified.Unix() != chapter.Modified.Unix() {
+			continue
+		}
+		doc, err := r.readXhtml(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if dom.GetAttribute(dom.FindNodeByTag(doc, "html"), "lang") != info.Language {
+			continue
+		}
+		reused := reusedChapter{Files: []*zip.File{f}}
+		for j := 1; r.files["book/"+chapterPartName(i, j)+".xhtml"] != nil; j++ {
+			reused.Files = append(reused.Files, r.files["book/"+chapterPartName(i, j)+".xhtml"])
+		}
+		for j, part := range reused.Files {
+			partDoc := doc
+			if j > 0 {
+				if partDoc, err = r.readXhtml(part.Name); err != nil {
+					return fmt.Errorf("%s: %w", part.Name, err)
+				}
+			}
+			for _, img := range dom.FindNodesByTagAndAttrib(partDoc, "img", "", "") {
+				src := dom.GetAttribute(img, "src")
+				imageName := resolveHref(part.Name, src)
+				if strings.Contains(src, ":") || r.files[imageName] == nil {
+					continue
+				}
+				reused.Images = append(reused.Images, epubImage{
+					Href:      strings.TrimPrefix(imageName, "book/"),
+					MediaType: mediaTypes[imageName],
+					File:      r.files[imageName],
+				})
+			}
+		}
+		if previous := readChapter(doc, f.Modified); previous.Title == chapter.Title && previous.Url == chapter.Url {
+			reuse[i] = reused
+		}
+	}
+	return info.writeEpub(dst, options, reuse)
+}
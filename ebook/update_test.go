@@ -0,0 +1,78 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/dom"
+	"github.com/HalCanary/facility/expect"
+)
+
+func rawZipEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name == name {
+			r, err := f.OpenRaw()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(r)
+			return b
+		}
+	}
+	return nil
+}
+
+func TestUpdateEpub(t *testing.T) {
+	modified := time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC)
+	book := makeTestBook(modified)
+	book.Chapters = book.Chapters[:2]
+	var old bytes.Buffer
+	if err := book.Write(&old); err != nil {
+		t.Fatal(err)
+	}
+
+	book = makeTestBook(modified)
+	book.Chapters[1].Modified = book.Chapters[1].Modified.Add(time.Minute)
+	book.Chapters[1].Content = dom.Elem("p", dom.Text("changed"))
+	var updated, expected bytes.Buffer
+	if err := book.UpdateEpub(&updated, bytes.NewReader(old.Bytes()), int64(old.Len()), EpubOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := book.Write(&expected); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, updated.Bytes())
+	expect.DeepEqual(t, readZipEntries(t, expected.Bytes()), readZipEntries(t, updated.Bytes()))
+	expect.True(t, bytes.Equal(rawZipEntry(t, old.Bytes(), "book/0000.xhtml"), rawZipEntry(t, updated.Bytes(), "book/0000.xhtml")))
+
+	// Unchanged chapters are copied, not rendered.
+	book.Chapters[0].Content = dom.Elem("p", dom.Text("ignored"))
+	updated.Reset()
+	if err := book.UpdateEpub(&updated, bytes.NewReader(old.Bytes()), int64(old.Len()), EpubOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect.Equal(t, readZipEntries(t, expected.Bytes())["book/0000.xhtml"], readZipEntries(t, updated.Bytes())["book/0000.xhtml"])
+
+	// Chapters are rendered again when the options that shape them change.
+	for _, options := range []EpubOptions{{Endnotes: true}, {KeepNotes: true}, {MaxChapterSize: -1}} {
+		updated.Reset()
+		if err := book.UpdateEpub(&updated, bytes.NewReader(old.Bytes()), int64(old.Len()), options); err != nil {
+			t.Fatal(err)
+		}
+		entries := readZipEntries(t, updated.Bytes())
+		expect.True(t, strings.Contains(entries["book/0000.xhtml"], "ignored"))
+		expect.True(t, strings.Contains(entries["book/content.opf"], options.chapterLayout()))
+	}
+}
@@ -0,0 +1,305 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// How serious a problem found by `Validate` is.
+type Severity int
+
+const (
+	// The Epub is usable, but something is not as it should be.
+	SeverityWarning Severity = iota
+	// The Epub violates the specification; some readers will reject it.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "ERROR"
+	}
+	return "WARNING"
+}
+
+// A problem found by `Validate`.
+type ValidationIssue struct {
+	Severity Severity
+	Path     string // The file within the archive, if any.
+	Message  string
+}
+
+func (v ValidationIssue) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("%s: %s", v.Severity, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Severity, v.Path, v.Message)
+}
+
+// The problems found by `Validate`.
+type ValidationIssues []ValidationIssue
+
+// Return true if any of the issues is an error.
+func (issues ValidationIssues) HasErrors() bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+var modifiedRegexp = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}Z$")
+
+type validator struct {
+	epubReader
+	issues ValidationIssues
+	ids    map[string]map[string]bool // ids declared in each document.
+	links  []validatorLink
+}
+
+type validatorLink struct {
+	Source string
+	Href   string
+}
+
+func (v *validator) errorf(path, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{SeverityError, path, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(path, format string, args ...any) {
+	v.issues = append(v.issues, ValidationIssue{SeverityWarning, path, fmt.Sprintf(format, args...)})
+}
+
+// Check the structure of an Epub archive: the mimetype file, the container,
+// the package document's metadata, manifest and spine, the navigation
+// documents, that every XHTML file is well-formed, and that internal links
+// resolve.  The error is non-nil only if `src` is not a zip archive.
+func Validate(src io.ReaderAt, size int64) (ValidationIssues, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, err
+	}
+	v := validator{
+		epubReader: epubReader{files: make(map[string]*zip.File, len(zr.File))},
+		ids:        map[string]map[string]bool{},
+	}
+	for _, f := range zr.File {
+		if v.files[f.Name] != nil {
+			v.errorf(f.Name, "duplicate file")
+		}
+		v.files[f.Name] = f
+	}
+	v.checkMimetype(zr.File)
+	opfPath, err := v.rootfile()
+	if err != nil {
+		v.errorf("META-INF/container.xml", "%v", err)
+		return v.issues, nil
+	}
+	v.checkPackage(opfPath)
+	v.checkLinks()
+	return v.issues, nil
+}
+
+func (v *validator) checkMimetype(files []*zip.File) {
+	if len(files) == 0 || files[0].Name != "mimetype" {
+		v.errorf("mimetype", "must be the first file in the archive")
+		if v.files["mimetype"] == nil {
+			return
+		}
+	}
+	f := v.files["mimetype"]
+	if f.Method != zip.Store {
+		v.errorf("mimetype", "must be stored without compression")
+	}
+	if len(f.Extra) > 0 {
+		v.warnf("mimetype", "has an extra field")
+	}
+	if data, err := v.read("mimetype"); err != nil {
+		v.errorf("mimetype", "%v", err)
+	} else if string(data) != "application/epub+zip" {
+		v.errorf("mimetype", "content is %q, not \"application/epub+zip\"", data)
+	}
+}
+
+func (v *validator) checkPackage(opfPath string) {
+	var opf opfDocument
+	if err := v.readXml(opfPath, &opf); err != nil {
+		v.errorf(opfPath, "%v", err)
+		return
+	}
+	epub3 := strings.HasPrefix(opf.Version, "3.")
+	if !epub3 && !strings.HasPrefix(opf.Version, "2.") {
+		v.errorf(opfPath, "unknown version %q", opf.Version)
+	}
+
+	var identifier, title, language bool
+	var modified []string
+	for _, elem := range opf.Metadata.Elements {
+		if elem.XMLName.Space == dcNamespace {
+			switch elem.XMLName.Local {
+			case "identifier":
+				identifier = identifier || xmlAttribute(elem.Attributes, "id") == opf.UniqueIdentifier
+			case "title":
+				title = true
+			case "language":
+				language = true
+			}
+		} else if elem.XMLName.Local == "meta" && xmlAttribute(elem.Attributes, "property") == "dcterms:modified" {
+			modified = append(modified, strings.TrimSpace(elem.Value))
+		}
+	}
+	if !identifier {
+		v.errorf(opfPath, "no dc:identifier with id %q", opf.UniqueIdentifier)
+	}
+	if !title {
+		v.errorf(opfPath, "no dc:title")
+	}
+	if !language {
+		v.errorf(opfPath, "no dc:language")
+	}
+	if epub3 {
+		if len(modified) != 1 {
+			v.errorf(opfPath, "expected one dcterms:modified, found %d", len(modified))
+		}
+		for _, m := range modified {
+			if !modifiedRegexp.MatchString(m) {
+				v.errorf(opfPath, "dcterms:modified %q is not of the form CCYY-MM-DDThh:mm:ssZ", m)
+			}
+		}
+	}
+
+	items := map[string]xmlItem{}
+	listed := map[string]bool{opfPath: true}
+	navCount := 0
+	for _, item := range opf.ManifestItems {
+		if _, ok := items[item.Id]; ok {
+			v.errorf(opfPath, "duplicate manifest id %q", item.Id)
+		}
+		items[item.Id] = item
+		name := resolveHref(opfPath, item.Href)
+		listed[name] = true
+		if item.MediaType == "" {
+			v.errorf(opfPath, "manifest item %q has no media-type", item.Id)
+		}
+		if v.files[name] == nil {
+			v.errorf(opfPath, "manifest item %q: missing file %q", item.Id, name)
+			continue
+		}
+		if hasProperty(item.Attributes, "nav") {
+			navCount++
+		}
+		switch item.MediaType {
+		case "application/xhtml+xml", "application/x-dtbncx+xml":
+			v.scanXml(name)
+		}
+	}
+	if epub3 && navCount != 1 {
+		v.errorf(opfPath, "expected one nav document, found %d", navCount)
+	}
+	var names []string
+	for name := range v.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !listed[name] && name != "mimetype" && !strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/") {
+			v.warnf(name, "not listed in the manifest")
+		}
+	}
+
+	if opf.Spine.Toc != "" {
+		if item, ok := items[opf.Spine.Toc]; !ok || item.MediaType != "application/x-dtbncx+xml" {
+			v.errorf(opfPath, "spine toc %q is not an NCX manifest item", opf.Spine.Toc)
+		}
+	} else if !epub3 {
+		v.errorf(opfPath, "spine has no toc")
+	}
+	if len(opf.Spine.Itemrefs) == 0 {
+		v.errorf(opfPath, "spine is empty")
+	}
+	inSpine := map[string]bool{}
+	for _, itemref := range opf.Spine.Itemrefs {
+		item, ok := items[itemref.Idref]
+		if !ok {
+			v.errorf(opfPath, "spine itemref %q is not in the manifest", itemref.Idref)
+		} else if item.MediaType != "application/xhtml+xml" {
+			v.warnf(opfPath, "spine item %q has media-type %q", itemref.Idref, item.MediaType)
+		}
+		if inSpine[itemref.Idref] {
+			v.errorf(opfPath, "spine itemref %q is repeated", itemref.Idref)
+		}
+		inSpine[itemref.Idref] = true
+	}
+	for _, ref := range opf.GuideRefs {
+		v.links = append(v.links, validatorLink{opfPath, ref.Href})
+	}
+}
+
+// Check that the document is well-formed XML, and record its ids and links.
+func (v *validator) scanXml(name string) {
+	data, err := v.read(name)
+	if err != nil {
+		v.errorf(name, "%v", err)
+		return
+	}
+	ids := map[string]bool{}
+	v.ids[name] = ids
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			v.errorf(name, "not well-formed: %v", err)
+			return
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			for _, attr := range start.Attr {
+				switch attr.Name.Local {
+				case "id":
+					if ids[attr.Value] {
+						v.errorf(name, "duplicate id %q", attr.Value)
+					}
+					ids[attr.Value] = true
+				case "href", "src":
+					if start.Name.Local != "base" {
+						v.links = append(v.links, validatorLink{name, attr.Value})
+					}
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) checkLinks() {
+	for _, link := range v.links {
+		u, err := url.Parse(link.Href)
+		if err != nil {
+			v.errorf(link.Source, "bad link %q: %v", link.Href, err)
+			continue
+		}
+		if u.Scheme != "" || u.Host != "" {
+			continue // External.
+		}
+		target := resolveHref(link.Source, link.Href)
+		if v.files[target] == nil {
+			v.errorf(link.Source, "link %q: missing file %q", link.Href, target)
+			continue
+		}
+		if ids, ok := v.ids[target]; ok && u.Fragment != "" && !ids[u.Fragment] {
+			v.warnf(link.Source, "link %q: no element with id %q in %q", link.Href, u.Fragment, target)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ebook
+
+// Copyright 2022 Hal Canary
+// Use of this program is governed by the file LICENSE.
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HalCanary/facility/expect"
+)
+
+// Fail the test if the Epub has any validation issues.
+func expectValidEpub(t *testing.T, data []byte) {
+	t.Helper()
+	issues, err := Validate(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range issues {
+		t.Error(issue)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := makeTestBook(time.Date(2022, 10, 1, 12, 30, 0, 0, time.UTC)).Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	expectValidEpub(t, buffer.Bytes())
+
+	// Copy the book, breaking it along the way.
+	src := buffer.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broken bytes.Buffer
+	zw := zip.NewWriter(&broken)
+	for _, f := range zr.File {
+		switch f.Name {
+		case "mimetype":
+			w, _ := zw.Create(f.Name) // Compressed.
+			w.Write([]byte("application/epub+zip"))
+		case "book/0001.xhtml":
+			// Missing.
+		case "book/0002.xhtml":
+			w, _ := zw.Create(f.Name)
+			w.Write([]byte("<html><body><p>unclosed</body></html>"))
+		default:
+			zw.Copy(f)
+		}
+	}
+	w, _ := zw.Create("book/extra.css")
+	w.Write([]byte("p{}"))
+	zw.Close()
+	data := broken.Bytes()
+	issues, err := Validate(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect.True(t, issues.HasErrors())
+	var messages []string
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	expect.Equal(t, strings.Join([]string{
+		`ERROR: mimetype: must be stored without compression`,
+		`ERROR: book/content.opf: manifest item "ch0001": missing file "book/0001.xhtml"`,
+		`ERROR: book/0002.xhtml: not well-formed: XML syntax error on line 1: element <p> closed by </body>`,
+		`WARNING: book/extra.css: not listed in the manifest`,
+		`ERROR: book/toc.xhtml: link "0001.xhtml": missing file "book/0001.xhtml"`,
+		`ERROR: book/toc.ncx: link "0001.xhtml": missing file "book/0001.xhtml"`,
+	}, "\n"), strings.Join(messages, "\n"))
+}
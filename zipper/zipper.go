@@ -60,3 +60,10 @@ func (zw *Zipper) CreateDeflate(name string, mod time.Time) io.Writer {
 func (zw *Zipper) CreateStore(name string, mod time.Time) io.Writer {
 	return zw.create(name, zip.Store, mod)
 }
+
+// Copy a file from another archive, without decompressing and recompressing it.
+func (zw *Zipper) Copy(f *zip.File) {
+	if zw.Error == nil {
+		zw.Error = zw.ZipWriter.Copy(f)
+	}
+}